@@ -11,13 +11,24 @@ Creating a new error or wrapping an error are as simple as:
 	ctxerr.Newf(ctx, "<code>", "%s", "<vars>")
 	ctxerr.Wrap(ctx, err, "<code>", "<message>")
 	ctxerr.Wrapf(ctx, err, "<code>", "%s", "<var>")
+	ctxerr.NewFields(ctx, "<code>", map[string]any{"<field>": "<value>"}, "<message>")
+	ctxerr.WrapFields(ctx, err, "<code>", map[string]any{"<field>": "<value>"}, "<message>")
+	ctxerr.Join(ctx, "<code>", err1, err2)
 
 A quick wrap function is available to avoid needing to create unused codes and messages.
 This function calls Wrap with an empty string for the code no message.
+Set 'QuickWrapUsesCallerName' on an instance to use the calling function's name as the message instead.
 
 	ctxerr.QuickWrap(ctx, err)
 
 Note: Wrapping nil will return nil.
+So wrapping can be done in a single line without checking for nil first.
+
+	return ctxerr.Wrap(ctx, foo(), "<code>", "<message>")
+
+To wrap multiple errors that may be nil and join them use:
+
+	ctxerr.WrapAll(ctx, "<code>", err1, err2)
 
 # Context
 
@@ -27,16 +38,28 @@ Adding information (aka fields) to a context is done by:
 	ctx = ctxerr.SetField(ctx, "field", "value")
 	ctx = ctxerr.SetFields(ctx, map[string]any{"foo": "bar", "baz": 0})
 
+When setting many fields at once a builder avoids copying the fields for each one:
+
+	ctx = ctxerr.NewFieldBuilder(ctx).Set("foo", "bar").Set("baz", 0).Context()
+
 Some common field keys have been predefined to be used in this or sub packages.
 This includes 'FieldKeyCode' which is used to set the 'code' passed into the New/Wrap functions on the context.
 See the HTTP section below for more examples.
 
 The function 'Fields' allows retrieving the fields added to the context.
 Using this for goroutines ensures all the data gets propagated.
+'DetachFields' does this for fire-and-forget work that should not be canceled with the parent context.
+'CopyFields' adds the fields onto another context keeping its deadline and cancellation.
 
-	nctx := ctxerr.SetFields(context.Background(), ctxerr.Fields(ctx))
+	nctx := ctxerr.DetachFields(ctx)
 	go foo(nctx)
 
+	jctx := ctxerr.CopyFields(jobCtx, ctx)
+
+Errors are safe to read from multiple goroutines.
+The fields are copied from the context when the error is created so 'AllFields' is not affected by later changes.
+The map returned by 'Fields' is shared so it should never be modified, use 'FieldsCopy' if you need to change it.
+
 # Handle
 
 Handle exists to make sure all errors are handled in the say way.
@@ -45,7 +68,7 @@ It will run through all hooks added through configuration or fallback to the Def
 
 (i.e. HTTP handle functions or goroutines)
 
-	nctx := ctxerr.SetFields(context.Background(), ctxerr.Fields(ctx))
+	nctx := ctxerr.DetachFields(ctx)
 	go func(ctx context.Context){
 		if err := foo(ctx) {
 			err = ctxerr.QuickWrap(ctx, err)
@@ -67,6 +90,14 @@ Note: If you are not adding a custom logging hook it may be useful to add the de
 	ctxerr.AddHandleHook(metricOnError)
 	ctxerr.AddHandleHook(DefaultLogHook)
 
+To record a full stack trace of where errors are created add the stack hook.
+
+	ctxerr.AddCreateHook(ctxerr.SetStackHook)
+
+If you use 'log/slog' there is a hook that logs the fields as attributes.
+
+	ctxerr.AddHandleHook(ctxerr.SlogHandleHook(slog.Default()))
+
 There is an http subpackage for handling HTTP errors.
 The function included returns a standardized struct filled in with details of the error.
 There are fields key constansts to help with this.
@@ -78,6 +109,7 @@ An "Action" is a user facing error that a user can take an action on to fix.
 There are helper http functions that set the status code and action in one call.
 
 	ctxerr.NewHTTP(ctx, "<code>", "<action>", http.StatusBadRequest, "<message>")
+	ctxerr.NewHTTPStatus(ctx, http.StatusNotFound, "<message>")
 	ctxerr.NewHTTPf(ctx, "<code>", "<action>", http.StatusConflict, "%s", "<vars>")
 	ctxerr.WrapHTTP(ctx, err, "<code>", "<action>", http.StatusBadRequest, "<message>")
 	ctxerr.WrapHTTPf(ctx, err, "<code>", "<action>", http.StatusBadRequest, "%s", "<vars>")
@@ -89,33 +121,165 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net/http"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"runtime"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/mvndaai/ctxerr/joinederr"
 )
 
-var global Instance
+var (
+	global   atomic.Pointer[Instance]
+	globalMu sync.Mutex
+)
 
 func init() {
-	global = NewInstance()
+	instanceCreateHooks = map[uintptr]func(Instance, context.Context, string, error) context.Context{
+		funcPC(SetCodeHook):                 Instance.SetCodeHook,
+		funcPC(SetLocationHook):             Instance.SetLocationHook,
+		funcPC(SetDetailedLocationHook):     Instance.SetDetailedLocationHook,
+		funcPC(SetStackHook):                Instance.SetStackHook,
+		funcPC(ValidateRegisteredCodeHook):  Instance.ValidateRegisteredCodeHook,
+		funcPC(SetCancellationCategoryHook): Instance.SetCancellationCategoryHook,
+	}
+
+	in := NewInstance()
+	global.Store(&in)
+}
+
+// Global gets a copy of the instance used by the package level functions
+// Save it in tests to restore it later with SetGlobal, changing the copy does not change the global
+func Global() Instance { return global.Load().clone() }
+
+// SetGlobal replaces the instance used by the package level functions with a copy of in
+// It is safe to call while other goroutines use them
+func SetGlobal(in Instance) {
+	in = in.clone()
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	global.Store(&in)
+}
+
+// updateGlobal changes a copy of the global instance then replaces it so readers never see a partial change
+func updateGlobal(f func(in *Instance)) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	in := global.Load().clone()
+	f(&in)
+	global.Store(&in)
+}
+
+// clone copies the instance so appending or setting on it does not change values shared with the original
+func (in Instance) clone() Instance {
+	in.CreateHooks = slices.Clip(in.CreateHooks)
+	in.HandleHooks = slices.Clip(in.HandleHooks)
+	in.HandleHooksCtx = slices.Clip(in.HandleHooksCtx)
+	in.FieldHooks = slices.Clip(in.FieldHooks)
+	in.FieldHooksWithKey = slices.Clip(in.FieldHooksWithKey)
+	in.FieldsAsSlice = slices.Clip(in.FieldsAsSlice)
+	in.DeduplicateSliceFields = slices.Clip(in.DeduplicateSliceFields)
+	in.CallerSkipPrefixes = slices.Clip(in.CallerSkipPrefixes)
+	in.GetFieldsFuncs = slices.Clip(in.GetFieldsFuncs)
+	in.GetFieldsFuncsCtx = slices.Clip(in.GetFieldsFuncsCtx)
+	in.FieldKeyMap = maps.Clone(in.FieldKeyMap)
+	in.Codes = maps.Clone(in.Codes)
+	in.FieldValueMarshalers = maps.Clone(in.FieldValueMarshalers)
+	in.CodeDefaults = maps.Clone(in.CodeDefaults)
+	return in
 }
 
 // Instance creates a local instance so you can have a different setup than global
+// Configure a local instance before using it from other goroutines, the package level Add functions are safe at any time
 type Instance struct {
 	// CreateHooks are functions that run on creation to set fields on context
 	CreateHooks []func(ctx context.Context, code string, wrapping error) context.Context
 	// HandleHooks are functions that run on ctxerr.Handle
 	HandleHooks []func(error)
+	// HandleHooksCtx are functions that run on ctxerr.Handle after HandleHooks and also get a context
+	HandleHooksCtx []func(context.Context, error)
 	// FieldHooks are functions that run on ctxerr.SetField(s)
 	FieldHooks []func(context.Context, any) any
+	// FieldHooksWithKey are functions that run on ctxerr.SetField(s) after FieldHooks and also get the key
+	FieldHooksWithKey []func(ctx context.Context, key string, value any) any
 	// FieldsAsSlice are keys that get gathered as a slice in ctxerr.AllFields
 	FieldsAsSlice []string
+	// DeduplicateSliceFields are slice keys where consecutive duplicate values are collapsed in ctxerr.AllFields
+	DeduplicateSliceFields []string
 	// GetFieldsFuncs are functions that get the fieldss from an error
 	GetFieldsFuncs []func(error) map[string]any
+	// GetFieldsFuncsCtx are functions that get the fields from an error and its context, they run after GetFieldsFuncs
+	GetFieldsFuncsCtx []func(context.Context, error) map[string]any
+	// QuickWrapUsesCallerName makes ctxerr.QuickWrap use the calling function's name as the message
+	QuickWrapUsesCallerName bool
+	// DefaultCode is used by New and Wrap when no code is passed in and none is on the context or wrapped error
+	DefaultCode string
+	// MaxFieldValueBytes truncates field values in ctxerr.AllFields that are larger, 0 is unlimited
+	MaxFieldValueBytes int
+	// JoinedFields is how fields with the same key from different branches of joined errors are combined
+	JoinedFields JoinedFieldsMode
+	// LogFormat is how DefaultLogHook renders fields, defaults to LogFormatJSON
+	LogFormat LogFormat
+	// MessageSeparator joins the message of an error with the wrapped error's message, defaults to " : "
+	MessageSeparator string
+	// IncludeCodeInError adds the code of each error to its message like "[code] message : wrapped message"
+	IncludeCodeInError bool
+	// FieldKeyMap replaces standard field keys like FieldKeyCode with other keys when they are set and read
+	// Package level create hooks like SetCodeHook use the map of the instance running them
+	FieldKeyMap map[string]string
+	// Now is the clock used by hooks like RateLimitedHandleHook and JSONHandleHook, nil uses time.Now
+	Now func() time.Time
+	// Rand returns a number in [0.0,1.0) for SampledHandleHook, nil uses math/rand/v2
+	Rand func() float64
+	// Codes are the codes with descriptions added with RegisterCode
+	Codes map[string]string
+	// PanicOnUnregisteredCode makes ValidateRegisteredCodeHook panic instead of handling an error, use it in tests or development
+	PanicOnUnregisteredCode bool
+	// MaxUnwrapDepth is how many wrapped errors deep AllFields, HasField and HasCategory look, 0 is unlimited
+	// Each wrapped error is one deeper and the branches of joined errors are at the depth of the joined error
+	MaxUnwrapDepth int
+	// MaxWrapDepth makes Wrap return the error as is when its chain already has that many errors, 0 is unlimited
+	MaxWrapDepth int
+	// FieldEncoder encodes fields for DefaultLogHook with LogFormatJSON and JSONHandleHook, nil uses json.Marshal
+	FieldEncoder func(map[string]any) ([]byte, error)
+	// CodeFormat is the format MustCode requires codes to match like regexp.MustCompile(`^[A-Z0-9_]+$`), nil allows any code
+	CodeFormat *regexp.Regexp
+	// FieldValueMarshalers convert field values of a type in ctxerr.AllFields like time.Duration into a string
+	FieldValueMarshalers map[reflect.Type]func(any) any
+	// SkipDoubleHandle makes Handle mark errors with MarkHandled and skip errors that are already handled
+	// Errors that are not a CtxErr cannot be marked in place so use the error from MarkHandled to skip them
+	SkipDoubleHandle bool
+	// SortSliceFields sorts the values of FieldsAsSlice keys in ctxerr.AllFields by their string form
+	SortSliceFields bool
+	// SplitJoinedOnHandle makes Handle run the hooks once for each leaf of joined errors like PerLeafHandleHook
+	SplitJoinedOnHandle bool
+	// CodeDefaults are the action and status code added with RegisterCodeDefaults
+	CodeDefaults map[string]CodeDefault
+	// CodePrecedence is which code ctxerr.AllFields uses when wrapped errors both have one, defaults to CodePrecedenceInnermost
+	CodePrecedence CodePrecedence
+	// CallerSkipPrefixes are function name prefixes skipped when getting the caller, added with AddCallerSkipPrefix
+	CallerSkipPrefixes []string
+}
+
+// CodeDefault is the action and status code New and Wrap set for a code when the context does not have them
+type CodeDefault struct {
+	Action     string
+	StatusCode int
 }
 
 // NewInstance creates a local instance with the default create hooks
@@ -126,11 +290,13 @@ func NewInstance() Instance {
 	// Always add the location of where the error happened
 	in.AddCreateHook(SetLocationHook)
 	// Gather keys like location as slice instead of just the deepest value
-	in.FieldsAsSlice = []string{FieldKeyLocation}
+	in.FieldsAsSlice = []string{FieldKeyLocation, FieldKeyStack}
 	// No built in hooks
 	in.FieldHooks = []func(context.Context, any) any{}
 	// Functions for getting the fields
 	in.GetFieldsFuncs = append(in.GetFieldsFuncs, DefaultFieldsFunc)
+	in.MessageSeparator = DefaultMessageSeparator
+	in.Codes = map[string]string{}
 	return in
 }
 
@@ -145,32 +311,201 @@ const (
 	FieldKeyCategory = "error_category"
 	// FieldKeyLocation shows the file location of the err
 	FieldKeyLocation = "error_location"
+	// FieldKeyStack is the stack trace of where the error was created when using SetStackHook
+	FieldKeyStack = "error_stack"
+	// FieldKeyPublicMessage is a message that is safe to show users instead of the error message
+	FieldKeyPublicMessage = "error_public_message"
+	// FieldKeyUnregisteredCode is the code that ValidateRegisteredCodeHook found was not registered
+	FieldKeyUnregisteredCode = "unregistered_code"
+	// FieldKeySuppressedCount is how many times RateLimitedHandleHook skipped the error since it was last handled
+	FieldKeySuppressedCount = "suppressed_count"
+	// FieldKeyRetryable tells if the operation that caused the error can be retried
+	FieldKeyRetryable = "error_retryable"
+	// FieldKeyRetryAfter is a time.Duration to wait before retrying the operation that caused the error
+	FieldKeyRetryAfter = "error_retry_after"
+	// FieldKeyCause is the message and fields of the root cause added with WrapCause
+	FieldKeyCause = "error_cause"
+	// FieldKeyQuiet set to true makes Handle skip DefaultLogHook when there are no handle hooks
+	FieldKeyQuiet = "error_quiet"
+	// FieldKeyHandled is set to true by MarkHandled
+	FieldKeyHandled = "error_handled"
+	// FieldKeyLevel is the Level to log the error at, errors without one are LevelError
+	FieldKeyLevel = "error_level"
+)
+
+// Level is how severe an error is for the log hooks
+type Level string
+
+const (
+	// LevelDebug is for errors that are only useful when debugging
+	LevelDebug Level = "debug"
+	// LevelInfo is for expected errors that are worth recording
+	LevelInfo Level = "info"
+	// LevelWarn is for errors that should be tracked but do not need action
+	LevelWarn Level = "warn"
+	// LevelError is the level of errors without FieldKeyLevel
+	LevelError Level = "error"
+)
+
+// Category is a typed category to avoid typos, HasCategory matches it with the same string
+type Category string
+
+const (
+	// CategoryCanceled is set by SetCancellationCategoryHook when wrapping context.Canceled
+	CategoryCanceled Category = "canceled"
+	// CategoryDeadlineExceeded is set by SetCancellationCategoryHook when wrapping context.DeadlineExceeded
+	CategoryDeadlineExceeded Category = "deadline_exceeded"
+	// CategoryNotFound is for errors where something does not exist
+	CategoryNotFound Category = "not_found"
+	// CategoryUnauthorized is for errors where the caller is not allowed
+	CategoryUnauthorized Category = "unauthorized"
+	// CategoryConflict is for errors where something conflicts with the current state
+	CategoryConflict Category = "conflict"
+	// CategoryInternal is for errors that are not the fault of the caller
+	CategoryInternal Category = "internal"
+	// CategoryPanic is set by Recover on errors created from a recovered panic
+	CategoryPanic Category = "panic"
+)
+
+// StandardFieldKeys are the keys of fields set by this package that SetFieldKeyPrefix replaces
+var StandardFieldKeys = []string{
+	FieldKeyCode, FieldKeyStatusCode, FieldKeyAction, FieldKeyCategory, FieldKeyLocation,
+	FieldKeyStack, FieldKeyPublicMessage, FieldKeyUnregisteredCode, FieldKeySuppressedCount,
+	FieldKeyRetryable, FieldKeyRetryAfter, FieldKeyCause, FieldKeyQuiet, FieldKeyHandled,
+	FieldKeyLevel,
+}
+
+// FieldKey gets the key to use for a standard field key like FieldKeyCode after FieldKeyMap
+func FieldKey(key string) string { return global.Load().FieldKey(key) }
+func (in Instance) FieldKey(key string) string {
+	if k, ok := in.FieldKeyMap[key]; ok {
+		return k
+	}
+	return key
+}
+
+// SetFieldKeyPrefix maps all of the StandardFieldKeys to the prefix and the key without "error_"
+// For example the prefix "err." makes FieldKeyCode "err.code"
+func SetFieldKeyPrefix(prefix string) {
+	updateGlobal(func(in *Instance) { in.SetFieldKeyPrefix(prefix) })
+}
+func (in *Instance) SetFieldKeyPrefix(prefix string) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call SetFieldKeyPrefix because ctxerr.Instance is nil")
+	}
+	if in.FieldKeyMap == nil {
+		in.FieldKeyMap = map[string]string{}
+	}
+	for _, k := range StandardFieldKeys {
+		in.FieldKeyMap[k] = prefix + strings.TrimPrefix(k, "error_")
+	}
+}
+
+// JoinedFieldsMode is a way of combining fields from joined errors in AllFields
+type JoinedFieldsMode int
+
+// CodePrecedence is which code AllFields uses when more than one error in the tree has a code
+type CodePrecedence int
+
+const (
+	// CodePrecedenceInnermost uses the code of the deepest error like every other field, it is the default
+	// The code of a Join still wins over the codes of its branches
+	CodePrecedenceInnermost CodePrecedence = iota
+	// CodePrecedenceOutermost uses the code of the outermost error like GetCode
+	CodePrecedenceOutermost
+)
+
+const (
+	// JoinedFieldsLastWins uses the value from the last joined error
+	JoinedFieldsLastWins JoinedFieldsMode = iota
+	// JoinedFieldsFirstWins uses the value from the first joined error
+	JoinedFieldsFirstWins
+	// JoinedFieldsCollect gathers the different values from the joined errors into a slice
+	JoinedFieldsCollect
+)
+
+// LogFormat is a way of rendering fields in DefaultLogHook
+type LogFormat int
+
+const (
+	// LogFormatJSON renders fields as a JSON object
+	LogFormatJSON LogFormat = iota
+	// LogFormatLogfmt renders fields as sorted key=value pairs
+	LogFormatLogfmt
 )
 
+// DefaultMessageSeparator is used between messages of wrapped errors when an instance has none set
+const DefaultMessageSeparator = " : "
+
 // FieldsKey is the key used to add and decode fields on the context
 // Change or use it in other packages if you want to unify fields
 var FieldsKey any = contextKey("fields")
 
 // Handle should be called one per error to handle it when it can no logger be returned
-func Handle(err error) { global.Handle(err) }
-func (in Instance) Handle(err error) {
+// It returns true if a handle hook ran instead of the default log hook
+func Handle(err error) bool { return global.Load().Handle(err) }
+func (in Instance) Handle(err error) bool {
+	return in.HandleCtx(nil, err)
+}
+
+// HandleCtx is Handle with the context of where it is handled for hooks added with AddHandleHookCtx
+// A nil context uses the context of the error
+func HandleCtx(ctx context.Context, err error) bool { return global.Load().HandleCtx(ctx, err) }
+func (in Instance) HandleCtx(ctx context.Context, err error) bool {
 	if err == nil {
-		return
+		return false
+	}
+	if in.SkipDoubleHandle {
+		if in.IsHandled(err) {
+			return false
+		}
+		defer in.MarkHandled(err)
 	}
 
-	if len(in.HandleHooks) == 0 {
-		in.DefaultLogHook(err)
-		return
+	if in.SplitJoinedOnHandle {
+		if leaves := leafErrors(err); len(leaves) > 1 {
+			var ran bool
+			for _, leaf := range leaves {
+				ran = in.runHandleHooks(ctx, leaf) || ran
+			}
+			return ran
+		}
+	}
+	return in.runHandleHooks(ctx, err)
+}
+
+// runHandleHooks runs the handle hooks or the default log hook if there are none
+func (in Instance) runHandleHooks(ctx context.Context, err error) bool {
+	if len(in.HandleHooks) == 0 && len(in.HandleHooksCtx) == 0 {
+		if quiet, _ := in.outermostField(err, in.FieldKey(FieldKeyQuiet)); quiet != true {
+			in.DefaultLogHook(err)
+		}
+		return false
 	}
 
 	for _, hook := range in.HandleHooks {
 		hook(err)
 	}
+
+	if len(in.HandleHooksCtx) == 0 {
+		return true
+	}
+	if ctx == nil {
+		ctx = context.Background()
+		if ce, ok := As(err); ok && ce.Context() != nil {
+			ctx = ce.Context()
+		}
+	}
+	for _, hook := range in.HandleHooksCtx {
+		hook(ctx, err)
+	}
+	return true
 }
 
 // AddCreateHook adds a hooks that is called to update the context before the error is created
 func AddCreateHook(f func(ctx context.Context, code string, wrapping error) context.Context) {
-	global.AddCreateHook(f)
+	updateGlobal(func(in *Instance) { in.AddCreateHook(f) })
 }
 func (in *Instance) AddCreateHook(f func(ctx context.Context, code string, wrapping error) context.Context) {
 	if in == nil {
@@ -181,7 +516,7 @@ func (in *Instance) AddCreateHook(f func(ctx context.Context, code string, wrapp
 }
 
 // AddHandleHook adds a hook to be run on handling of an error
-func AddHandleHook(f func(error)) { global.AddHandleHook(f) }
+func AddHandleHook(f func(error)) { updateGlobal(func(in *Instance) { in.AddHandleHook(f) }) }
 func (in *Instance) AddHandleHook(f func(error)) {
 	if in == nil {
 		// cannot return an error so adding info to panic
@@ -190,8 +525,41 @@ func (in *Instance) AddHandleHook(f func(error)) {
 	in.HandleHooks = append(in.HandleHooks, f)
 }
 
+// MarkHandled sets FieldKeyHandled on the outermost CtxErr like AddFields, errors that are not a CtxErr get wrapped
+// The CtxErr is changed in place so every holder of the error sees it as handled
+// Like WithContext it should not be called while other goroutines are reading the error
+func MarkHandled(err error) error { return global.Load().MarkHandled(err) }
+func (in Instance) MarkHandled(err error) error {
+	return in.AddFields(err, map[string]any{in.FieldKey(FieldKeyHandled): true})
+}
+
+// IsHandled tells if the outermost CtxErr was marked with MarkHandled
+// Only its own fields are checked so a new error wrapping a handled error is not handled
+func IsHandled(err error) bool { return global.Load().IsHandled(err) }
+func (in Instance) IsHandled(err error) bool {
+	ce, ok := As(err)
+	if !ok {
+		return false
+	}
+	return in.errorFields(ce)[in.FieldKey(FieldKeyHandled)] == true
+}
+
+// AddHandleHookCtx adds a hook to be run on handling of an error that also gets the context
+func AddHandleHookCtx(f func(context.Context, error)) {
+	updateGlobal(func(in *Instance) { in.AddHandleHookCtx(f) })
+}
+func (in *Instance) AddHandleHookCtx(f func(context.Context, error)) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call AddHandleHookCtx because ctxerr.Instance is nil")
+	}
+	in.HandleHooksCtx = append(in.HandleHooksCtx, f)
+}
+
 // AddFieldHooks adds a hook to be run on handling of an error
-func AddFieldHook(f func(context.Context, any) any) { global.AddFieldHook(f) }
+func AddFieldHook(f func(context.Context, any) any) {
+	updateGlobal(func(in *Instance) { in.AddFieldHook(f) })
+}
 func (in *Instance) AddFieldHook(f func(context.Context, any) any) {
 	if in == nil {
 		// cannot return an error so adding info to panic
@@ -200,8 +568,22 @@ func (in *Instance) AddFieldHook(f func(context.Context, any) any) {
 	in.FieldHooks = append(in.FieldHooks, f)
 }
 
+// AddFieldHookWithKey adds a hook that also gets the key of the field to be run on setting a field
+func AddFieldHookWithKey(f func(ctx context.Context, key string, value any) any) {
+	updateGlobal(func(in *Instance) { in.AddFieldHookWithKey(f) })
+}
+func (in *Instance) AddFieldHookWithKey(f func(ctx context.Context, key string, value any) any) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call AddFieldHookWithKey because ctxerr.Instance is nil")
+	}
+	in.FieldHooksWithKey = append(in.FieldHooksWithKey, f)
+}
+
 // AddFieldsFuncs adds a function that can be used to get fields from an error
-func AddFieldsFunc(f func(error) map[string]any) { global.AddFieldsFunc(f) }
+func AddFieldsFunc(f func(error) map[string]any) {
+	updateGlobal(func(in *Instance) { in.AddFieldsFunc(f) })
+}
 func (in *Instance) AddFieldsFunc(f func(error) map[string]any) {
 	if in == nil {
 		// cannot return an error so adding info to panic
@@ -210,6 +592,74 @@ func (in *Instance) AddFieldsFunc(f func(error) map[string]any) {
 	in.GetFieldsFuncs = append(in.GetFieldsFuncs, f)
 }
 
+// AddFieldsFuncCtx adds a function that can be used to get fields from an error and its context
+// The context is from the error's Context() method when it has one, otherwise it is context.Background()
+func AddFieldsFuncCtx(f func(context.Context, error) map[string]any) {
+	updateGlobal(func(in *Instance) { in.AddFieldsFuncCtx(f) })
+}
+func (in *Instance) AddFieldsFuncCtx(f func(context.Context, error) map[string]any) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call AddFieldsFuncCtx because ctxerr.Instance is nil")
+	}
+	in.GetFieldsFuncsCtx = append(in.GetFieldsFuncsCtx, f)
+}
+
+// RegisterCode adds a code with its description so ValidateRegisteredCodeHook knows it is valid
+func RegisterCode(code, description string) {
+	updateGlobal(func(in *Instance) { in.RegisterCode(code, description) })
+}
+func (in *Instance) RegisterCode(code, description string) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call RegisterCode because ctxerr.Instance is nil")
+	}
+	if in.Codes == nil {
+		in.Codes = map[string]string{}
+	}
+	in.Codes[code] = description
+}
+
+// RegisterCodeDefaults sets the action and status code for errors created with the code
+// Values on the context win, an empty action or 0 status code is not set
+func RegisterCodeDefaults(code, action string, status int) {
+	updateGlobal(func(in *Instance) { in.RegisterCodeDefaults(code, action, status) })
+}
+func (in *Instance) RegisterCodeDefaults(code, action string, status int) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call RegisterCodeDefaults because ctxerr.Instance is nil")
+	}
+	if in.CodeDefaults == nil {
+		in.CodeDefaults = map[string]CodeDefault{}
+	}
+	in.CodeDefaults[code] = CodeDefault{Action: action, StatusCode: status}
+}
+
+// RegisteredCodes gets a copy of the registered codes with their descriptions for generating docs
+func RegisteredCodes() map[string]string { return global.Load().RegisteredCodes() }
+func (in Instance) RegisteredCodes() map[string]string {
+	return maps.Clone(in.Codes)
+}
+
+// MustCode returns the code or panics if it is empty, does not match CodeFormat, or is not registered
+// Codes are only required to be registered once any code is registered, use it for package level values
+//
+//	var CodeNotFound = ctxerr.MustCode("NOT_FOUND")
+func MustCode(code string) string { return global.Load().MustCode(code) }
+func (in Instance) MustCode(code string) string {
+	if code == "" {
+		panic("ctxerr: code cannot be empty")
+	}
+	if in.CodeFormat != nil && !in.CodeFormat.MatchString(code) {
+		panic(fmt.Sprintf("ctxerr: code %q does not match the format %s", code, in.CodeFormat))
+	}
+	if _, ok := in.Codes[code]; len(in.Codes) > 0 && !ok {
+		panic(fmt.Sprintf("ctxerr: code %q was not registered", code))
+	}
+	return code
+}
+
 // CtxErr is the interface that should be checked in a errors.As function
 type CtxErr interface {
 	error
@@ -220,18 +670,18 @@ type CtxErr interface {
 	Fields() map[string]any
 	Context() context.Context
 	WithContext(context.Context)
+
+	json.Marshaler
 }
 
 // New creates a new error
 func New(ctx context.Context, code string, message ...any) error {
-	return global.New(ctx, code, message...)
+	return global.Load().New(ctx, code, message...)
 }
 func (in Instance) New(ctx context.Context, code string, message ...any) error {
-	for _, hook := range in.CreateHooks {
-		ctx = hook(ctx, code, nil)
-	}
+	ctx = in.runCreateHooks(ctx, code, nil)
 
-	im := &impl{ctx: ctx}
+	im := in.newImpl(ctx, nil, "")
 	if len(message) > 0 && message[0] != nil {
 		im.msg = fmt.Sprint(message...)
 	}
@@ -240,73 +690,239 @@ func (in Instance) New(ctx context.Context, code string, message ...any) error {
 
 // Newf creates a new error message formatting
 func Newf(ctx context.Context, code, message string, messageArgs ...any) error {
-	return global.Newf(ctx, code, message, messageArgs...)
+	return global.Load().Newf(ctx, code, message, messageArgs...)
 }
 func (in Instance) Newf(ctx context.Context, code, message string, messageArgs ...any) error {
-	for _, hook := range in.CreateHooks {
-		ctx = hook(ctx, code, nil)
-	}
+	ctx = in.runCreateHooks(ctx, code, nil)
 
-	return &impl{
-		ctx: ctx,
-		msg: fmt.Sprintf(message, messageArgs...),
-	}
+	return in.newImpl(ctx, nil, fmt.Sprintf(message, messageArgs...))
 }
 
 // Wrap creates a new error with another wrapped under it
 func Wrap(ctx context.Context, err error, code string, message ...any) error {
-	return global.Wrap(ctx, err, code, message...)
+	return global.Load().Wrap(ctx, err, code, message...)
 }
 
 func (in Instance) Wrap(ctx context.Context, err error, code string, message ...any) error {
 	if err == nil {
 		return nil
 	}
-
-	for _, hook := range in.CreateHooks {
-		ctx = hook(ctx, code, err)
+	if in.wrapDepthReached(err) {
+		return err
 	}
 
-	im := &impl{
-		ctx:     ctx,
-		wrapped: err,
-	}
+	ctx = in.runCreateHooks(ctx, code, err)
 
+	im := in.newImpl(ctx, err, "")
 	if len(message) > 0 && message[0] != nil {
 		im.msg = fmt.Sprint(message...)
 	}
 	return im
 }
 
+// NewNoCtx creates a new error with a background context for code that does not have a context
+func NewNoCtx(code string, message ...any) error { return global.Load().NewNoCtx(code, message...) }
+func (in Instance) NewNoCtx(code string, message ...any) error {
+	return in.New(context.Background(), code, message...)
+}
+
+// WrapNoCtx creates a new error with a background context and another wrapped under it
+func WrapNoCtx(err error, code string, message ...any) error {
+	return global.Load().WrapNoCtx(err, code, message...)
+}
+func (in Instance) WrapNoCtx(err error, code string, message ...any) error {
+	return in.Wrap(context.Background(), err, code, message...)
+}
+
 // Wrapf creates a new error with a formatted message with another wrapped under it
 func Wrapf(ctx context.Context, err error, code, message string, messageArgs ...any) error {
-	return global.Wrapf(ctx, err, code, message, messageArgs...)
+	return global.Load().Wrapf(ctx, err, code, message, messageArgs...)
 }
 func (in Instance) Wrapf(ctx context.Context, err error, code, message string, messageArgs ...any) error {
 	if err == nil {
 		return nil
 	}
+	if in.wrapDepthReached(err) {
+		return err
+	}
 
-	for _, hook := range in.CreateHooks {
-		ctx = hook(ctx, code, err)
+	ctx = in.runCreateHooks(ctx, code, err)
+
+	return in.newImpl(ctx, err, fmt.Sprintf(message, messageArgs...))
+}
+
+// Join joins the non-nil errors and wraps them with the context and code
+// AllFields reports the code of the join over the codes of the branches, other fields of the branches win like with Wrap
+func Join(ctx context.Context, code string, errs ...error) error {
+	return global.Load().Join(ctx, code, errs...)
+}
+func (in Instance) Join(ctx context.Context, code string, errs ...error) error {
+	var err error
+	switch errs = slices.DeleteFunc(slices.Clone(errs), func(e error) bool { return e == nil }); len(errs) {
+	case 0:
+		return nil
+	case 1:
+		err = errs[0]
+	default:
+		err = errors.Join(errs...)
 	}
 
-	return &impl{
-		ctx:     ctx,
-		msg:     fmt.Sprintf(message, messageArgs...),
-		wrapped: err,
+	ctx = in.runCreateHooks(ctx, code, err)
+
+	im := in.newImpl(ctx, err, "")
+	im.shared = true
+	return im
+}
+
+// WrapCause wraps the error and records the cause under FieldKeyCause without adding it to the message or tree
+// The cause is a map with its "message" and "fields", use it to keep the why out of what is shown to users
+func WrapCause(ctx context.Context, err, cause error, code string, message ...any) error {
+	return global.Load().WrapCause(ctx, err, cause, code, message...)
+}
+func (in Instance) WrapCause(ctx context.Context, err, cause error, code string, message ...any) error {
+	if err == nil {
+		return nil
+	}
+	if cause != nil {
+		c := map[string]any{"message": cause.Error()}
+		if f := in.AllFields(cause); len(f) > 0 {
+			c["fields"] = f
+		}
+		ctx = in.SetField(ctx, in.FieldKey(FieldKeyCause), c)
+	}
+	return in.Wrap(ctx, err, code, message...)
+}
+
+// WrapAll wraps each non-nil error with the context and code then joins them
+// If all the errors are nil it returns nil
+func WrapAll(ctx context.Context, code string, errs ...error) error {
+	return global.Load().WrapAll(ctx, code, errs...)
+}
+func (in Instance) WrapAll(ctx context.Context, code string, errs ...error) error {
+	var wrapped []error
+	for _, err := range errs {
+		if err != nil {
+			wrapped = append(wrapped, in.Wrap(ctx, err, code, nil))
+		}
+	}
+	if len(wrapped) == 1 {
+		return wrapped[0]
+	}
+	return errors.Join(wrapped...)
+}
+
+// NewFields creates a new error after adding the fields to the context
+func NewFields(ctx context.Context, code string, fields map[string]any, message ...any) error {
+	return global.Load().NewFields(ctx, code, fields, message...)
+}
+func (in Instance) NewFields(ctx context.Context, code string, fields map[string]any, message ...any) error {
+	return in.New(in.SetFields(ctx, fields), code, message...)
+}
+
+// WrapFields creates a new error with another wrapped under it after adding the fields to the context
+func WrapFields(ctx context.Context, err error, code string, fields map[string]any, message ...any) error {
+	return global.Load().WrapFields(ctx, err, code, fields, message...)
+}
+func (in Instance) WrapFields(ctx context.Context, err error, code string, fields map[string]any, message ...any) error {
+	if err == nil {
+		return nil
+	}
+	return in.Wrap(in.SetFields(ctx, fields), err, code, message...)
+}
+
+// BadKey is the field key Wrapkv uses for a value without a string key like slog
+const BadKey = "!BADKEY"
+
+// Wrapkv creates a new error with another wrapped under it after adding alternating key/value pairs to the context
+// Like slog a value without a string key is added under BadKey instead of panicking
+func Wrapkv(ctx context.Context, err error, code string, kvs ...any) error {
+	return global.Load().Wrapkv(ctx, err, code, kvs...)
+}
+func (in Instance) Wrapkv(ctx context.Context, err error, code string, kvs ...any) error {
+	if err == nil {
+		return nil
+	}
+
+	fields := map[string]any{}
+	for len(kvs) > 0 {
+		k, ok := kvs[0].(string)
+		if !ok || len(kvs) == 1 {
+			fields[BadKey] = kvs[0]
+			kvs = kvs[1:]
+			continue
+		}
+		fields[k] = kvs[1]
+		kvs = kvs[2:]
+	}
+	return in.Wrap(in.SetFields(ctx, fields), err, code)
+}
+
+// WithCode sets the code on the context of the outermost CtxErr without wrapping it
+// Errors that are not a CtxErr get wrapped without a message or location
+// Like any field a code from a deeper error still wins in AllFields, GetCode returns this code
+func WithCode(err error, code string) error { return global.Load().WithCode(err, code) }
+func (in Instance) WithCode(err error, code string) error {
+	return in.AddFields(err, map[string]any{in.FieldKey(FieldKeyCode): code})
+}
+
+// AddFields sets the fields on the context of the outermost CtxErr without wrapping it
+// Use it to add fields only known when the error is handled, errors that are not a CtxErr get wrapped without a message or location
+func AddFields(err error, fields map[string]any) error { return global.Load().AddFields(err, fields) }
+func (in Instance) AddFields(err error, fields map[string]any) error {
+	if err == nil {
+		return nil
+	}
+
+	if ce, ok := As(err); ok {
+		ctx := ce.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ce.WithContext(in.SetFields(ctx, fields))
+		return err
 	}
+
+	return in.newImpl(in.SetFields(context.Background(), fields), err, "")
+}
+
+// Recover converts a value from recover() into an error with the stack of the panic and CategoryPanic
+// A recovered error is wrapped, nil returns nil
+//
+//	defer func() {
+//		if err := ctxerr.Recover(ctx, recover(), "<code>"); err != nil {
+//			ctxerr.Handle(err)
+//		}
+//	}()
+func Recover(ctx context.Context, recovered any, code string) error {
+	return global.Load().Recover(ctx, recovered, code)
+}
+func (in Instance) Recover(ctx context.Context, recovered any, code string) error {
+	if recovered == nil {
+		return nil
+	}
+
+	ctx = in.SetCategory(ctx, CategoryPanic)
+	ctx = in.SetField(ctx, in.FieldKey(FieldKeyStack), in.CallerStack(0))
+	if err, ok := recovered.(error); ok {
+		return in.Wrap(ctx, err, code, "panic")
+	}
+	return in.New(ctx, code, "panic: ", fmt.Sprint(recovered))
 }
 
-// QuickWrap will wrap an error with an empty code and the calling function's name as the message
+// QuickWrap will wrap an error with an empty code and no message
+// If QuickWrapUsesCallerName is set on the instance the calling function's name is used as the message
 func QuickWrap(ctx context.Context, err error) error {
-	return global.Wrap(ctx, err, "", nil)
+	return global.Load().QuickWrap(ctx, err)
 }
 func (in Instance) QuickWrap(ctx context.Context, err error) error {
+	if in.QuickWrapUsesCallerName && err != nil {
+		return in.Wrap(ctx, err, "", in.CallerFunc(0))
+	}
 	return in.Wrap(ctx, err, "", nil)
 }
 
 // Fields retrieves the fields from the context
+// The map is shared by every holder of the context so it should not be modified, use FieldsCopy instead
 func Fields(ctx context.Context) map[string]any {
 	if ctx == nil {
 		return nil
@@ -321,16 +937,54 @@ func Fields(ctx context.Context) map[string]any {
 	return nil
 }
 
+// FieldsCopy retrieves a copy of the fields from the context that is safe to modify
+func FieldsCopy(ctx context.Context) map[string]any {
+	fields := Fields(ctx)
+	if fields == nil {
+		return nil
+	}
+	f := make(map[string]any, len(fields))
+	for k, v := range fields {
+		f[k] = v
+	}
+	return f
+}
+
+// DetachFields creates a background context with only the fields from the context
+// It is not canceled when the context is, which is intended for fire-and-forget goroutines
+func DetachFields(ctx context.Context) context.Context {
+	return CopyFields(context.Background(), ctx)
+}
+
+// CopyFields adds the fields from src onto dst while keeping the deadline and values of dst
+// Fields from src replace fields on dst with the same key
+func CopyFields(dst, src context.Context) context.Context {
+	f := FieldsCopy(dst)
+	if f == nil {
+		f = map[string]any{}
+	}
+	for k, v := range Fields(src) {
+		f[k] = v
+	}
+	return context.WithValue(dst, FieldsKey, f)
+}
+
 // SetField adds a field onto the context
 func SetField(ctx context.Context, key string, value any) context.Context {
-	return global.SetField(ctx, key, value)
+	return global.Load().SetField(ctx, key, value)
 }
 func (in Instance) SetField(ctx context.Context, key string, value any) context.Context {
-	for _, f := range in.FieldHooks {
-		value = f(ctx, value)
+	value = in.runFieldHooks(ctx, key, value)
+	if value == OmitField {
+		return ctx
 	}
-	f := map[string]any{}
-	for k, v := range Fields(ctx) {
+	fields := Fields(ctx)
+	if len(fields) == 0 {
+		// Skip copying when there are no fields yet, which is common when creating errors
+		return context.WithValue(ctx, FieldsKey, map[string]any{key: value})
+	}
+	f := make(map[string]any, len(fields)+1)
+	for k, v := range fields {
 		f[k] = v
 	}
 	f[key] = value
@@ -339,107 +993,349 @@ func (in Instance) SetField(ctx context.Context, key string, value any) context.
 
 // SetFields can add multiple fields onto the context
 func SetFields(ctx context.Context, fields map[string]any) context.Context {
-	return global.SetFields(ctx, fields)
+	return global.Load().SetFields(ctx, fields)
 }
 func (in Instance) SetFields(ctx context.Context, fields map[string]any) context.Context {
+	return in.SetFieldsFunc(ctx, fields, nil)
+}
+
+// SetFieldsFunc adds multiple fields onto the context using merge for keys that are already on it
+// The new value has been through the field hooks, a nil merge replaces the old value like SetFields
+func SetFieldsFunc(ctx context.Context, fields map[string]any, merge func(key string, old, new any) any) context.Context {
+	return global.Load().SetFieldsFunc(ctx, fields, merge)
+}
+func (in Instance) SetFieldsFunc(ctx context.Context, fields map[string]any, merge func(key string, old, new any) any) context.Context {
 	f := map[string]any{}
 	for k, v := range Fields(ctx) {
 		f[k] = v
 	}
 	for k, v := range fields {
-		for _, f := range in.FieldHooks {
-			v = f(ctx, v)
+		v = in.runFieldHooks(ctx, k, v)
+		if v == OmitField {
+			continue
+		}
+		if old, ok := f[k]; ok && merge != nil {
+			v = merge(k, old, v)
 		}
 		f[k] = v
 	}
 	return context.WithValue(ctx, FieldsKey, f)
 }
 
-// CallerFunc gets the name of the calling function
-func CallerFunc(skip int) string {
-	f := "caller location unretrievable"
-	if pc, _, _, ok := runtime.Caller(skip + 1); ok {
-		if details := runtime.FuncForPC(pc); details != nil {
-			f = filepath.Base(details.Name())
-		}
-	}
+// DeleteField removes a field from the context
+func DeleteField(ctx context.Context, key string) context.Context {
+	return DeleteFields(ctx, key)
+}
 
-	// For helper functions QuickWrap and we still want the hook getting the location
-	if strings.HasPrefix(f, "ctxerr.") {
-		return CallerFunc(skip + 2)
+// DeleteFields removes multiple fields from the context
+// The same context is returned if none of the fields are on it
+func DeleteFields(ctx context.Context, keys ...string) context.Context {
+	fields := Fields(ctx)
+	if !slices.ContainsFunc(keys, func(k string) bool { _, ok := fields[k]; return ok }) {
+		return ctx
+	}
+	f := FieldsCopy(ctx)
+	for _, k := range keys {
+		delete(f, k)
 	}
+	return context.WithValue(ctx, FieldsKey, f)
+}
 
-	return f
+// FieldBuilder accumulates fields so they can be added to a context at once
+type FieldBuilder struct {
+	in     Instance
+	ctx    context.Context
+	fields map[string]any
 }
 
-// CallerFuncs is a shortcut for calling CallerFunc many times
-func CallerFuncs(skip, depth int) []string {
-	f := []string{}
-	for i := range depth {
-		f = append(f, CallerFunc(skip+i+1))
+// NewFieldBuilder creates a builder starting with the fields already on the context
+func NewFieldBuilder(ctx context.Context) *FieldBuilder { return global.Load().NewFieldBuilder(ctx) }
+func (in Instance) NewFieldBuilder(ctx context.Context) *FieldBuilder {
+	f := map[string]any{}
+	for k, v := range Fields(ctx) {
+		f[k] = v
 	}
-	return f
+	return &FieldBuilder{in: in, ctx: ctx, fields: f}
 }
 
-// AllFields unwraps the error collecting/replacing fields as it goes down the tree
-func AllFields(err error) map[string]any { return global.AllFields(err) }
-func (in Instance) AllFields(err error) map[string]any {
-	f := map[string]any{}
-	fieldFuncs := append([]func(error) map[string]any{}, in.GetFieldsFuncs...)
-	if len(fieldFuncs) == 0 {
-		fieldFuncs = append(fieldFuncs, DefaultFieldsFunc)
+// Set adds a field to the builder
+func (fb *FieldBuilder) Set(key string, value any) *FieldBuilder {
+	if value = fb.in.runFieldHooks(fb.ctx, key, value); value != OmitField {
+		fb.fields[key] = value
 	}
+	return fb
+}
 
-	iter := joinederr.NewDepthFirstIterator(err)
-	for {
-		err = iter.Next()
-		if err == nil {
-			return f
-		}
+// SetAll adds multiple fields to the builder
+func (fb *FieldBuilder) SetAll(fields map[string]any) *FieldBuilder {
+	for k, v := range fields {
+		fb.Set(k, v)
+	}
+	return fb
+}
 
-		fields := map[string]any{}
-		for _, fn := range fieldFuncs {
-			for k, v := range fn(err) {
-				fields[k] = v
-			}
-		}
-		for k, v := range fields {
-			if slices.Contains(in.FieldsAsSlice, k) {
-				if _, ok := f[k]; !ok {
-					f[k] = []any{}
-				}
+// Context creates a context with all of the fields on the builder
+func (fb *FieldBuilder) Context() context.Context {
+	f := make(map[string]any, len(fb.fields))
+	for k, v := range fb.fields {
+		f[k] = v
+	}
+	return context.WithValue(fb.ctx, FieldsKey, f)
+}
+
+// AddCallerSkipPrefix skips functions starting with the prefix like "myapp/errs." when getting the caller
+// Use it for wrappers around ctxerr so locations point at where they were called
+func AddCallerSkipPrefix(prefix string) {
+	updateGlobal(func(in *Instance) { in.AddCallerSkipPrefix(prefix) })
+}
+func (in *Instance) AddCallerSkipPrefix(prefix string) {
+	if in == nil {
+		// cannot return an error so adding info to panic
+		panic("cannot call AddCallerSkipPrefix because ctxerr.Instance is nil")
+	}
+	in.CallerSkipPrefixes = append(in.CallerSkipPrefixes, prefix)
+}
+
+// CallerFunc gets the name of the calling function
+func CallerFunc(skip int) string { return global.Load().CallerFunc(skip + 1) }
+func (in Instance) CallerFunc(skip int) string {
+	var pcs [16]uintptr
+	n := runtime.Callers(skip+2, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for n > 0 {
+		frame, more := frames.Next()
+		// For helper functions QuickWrap and we still want the hook getting the location
+		if !in.isHelperFunc(frame.Function) {
+			return filepath.Base(frame.Function)
+		}
+		if !more {
+			break
+		}
+	}
+	return "caller location unretrievable"
+}
 
-				f[k] = append(f[k].([]any), v)
+// CallerLocation gets the "file:line:function" of the calling function
+func CallerLocation(skip int) string { return global.Load().CallerLocation(skip + 1) }
+func (in Instance) CallerLocation(skip int) string {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "caller location unretrievable"
+	}
+	var name string
+	if details := runtime.FuncForPC(pc); details != nil {
+		name = details.Name()
+	}
+
+	// Skip helper functions the same as CallerFunc
+	if in.isHelperFunc(name) {
+		return in.CallerLocation(skip + 2)
+	}
+
+	return fmt.Sprintf("%s:%d:%s", filepath.Base(file), line, filepath.Base(name))
+}
+
+// CallerStack gets the stack of the calling function as "file:line:function" frames
+func CallerStack(skip int) []string { return global.Load().CallerStack(skip + 1) }
+func (in Instance) CallerStack(skip int) []string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	stack := []string{}
+	for {
+		frame, more := frames.Next()
+		fn := filepath.Base(frame.Function)
+		// Like CallerFunc skip the helper functions inside the package
+		if !(len(stack) == 0 && in.isHelperFunc(frame.Function)) {
+			stack = append(stack, fmt.Sprintf("%s:%d:%s", filepath.Base(frame.File), frame.Line, fn))
+		}
+		if !more {
+			return stack
+		}
+	}
+}
+
+// CallerFuncs is a shortcut for calling CallerFunc many times
+func CallerFuncs(skip, depth int) []string { return global.Load().CallerFuncs(skip+1, depth) }
+func (in Instance) CallerFuncs(skip, depth int) []string {
+	f := []string{}
+	for i := range depth {
+		f = append(f, in.CallerFunc(skip+i+1))
+	}
+	return f
+}
+
+// AllFields unwraps the error collecting/replacing fields as it goes down the tree
+// Deeper errors replace fields of the errors wrapping them, JoinedFields sets what happens between joined errors
+func AllFields(err error) map[string]any { return global.Load().AllFields(err) }
+func (in Instance) AllFields(err error) map[string]any {
+	f := map[string]any{}
+	for k, tf := range in.allTreeFields(err) {
+		f[k] = tf.value
+	}
+	return f
+}
+
+// allTreeFields gets the fields for AllFields with the depth each was found
+func (in Instance) allTreeFields(err error) map[string]treeField {
+	f := in.treeFields(err, 0)
+	if in.CodePrecedence == CodePrecedenceOutermost {
+		key := in.FieldKey(FieldKeyCode)
+		if code, ok := in.outermostField(err, key); ok {
+			depth, _ := in.outermostFieldDepth(err, key, 0)
+			f[key] = treeField{value: code, depth: depth}
+		}
+	}
+	for k, tf := range f {
+		switch vs := tf.value.(type) {
+		case collectedValues:
+			tf.value = []any(vs)
+			f[k] = tf
+		case []any:
+			if !in.isSliceField(k) {
 				continue
 			}
-			f[k] = v
+			if in.SortSliceFields {
+				slices.SortStableFunc(vs, func(a, b any) int { return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+			}
+			if slices.ContainsFunc(in.DeduplicateSliceFields, func(d string) bool { return in.FieldKey(d) == k }) {
+				tf.value = slices.CompactFunc(vs, func(a, b any) bool { return reflect.DeepEqual(a, b) })
+				f[k] = tf
+			}
 		}
 	}
+	return f
 }
 
-// HasField unwraps and checks if the error has a field in the error tree
-func HasField(err error, field string) bool { return global.HasField(err, field) }
-func (in Instance) HasField(err error, field string) bool {
-	fieldFuncs := append([]func(error) map[string]any{}, in.GetFieldsFuncs...)
-	if len(fieldFuncs) == 0 {
-		fieldFuncs = append(fieldFuncs, DefaultFieldsFunc)
+// ContextFromError creates a background context with all the fields of the error
+// Use it to create follow-up errors or logs with the same fields far from where the error was created
+func ContextFromError(err error) context.Context { return global.Load().ContextFromError(err) }
+func (in Instance) ContextFromError(err error) context.Context {
+	return in.SetFields(context.Background(), in.AllFields(err))
+}
+
+// OutermostFields gets only the fields of the outermost CtxErr, which is the context passed to the outermost New or Wrap
+// Unlike AllFields it does not include fields of the errors it wraps, errors that are not a CtxErr have no fields
+func OutermostFields(err error) map[string]any {
+	f := map[string]any{}
+	if ce, ok := As(err); ok {
+		for k, v := range ce.Fields() {
+			f[k] = v
+		}
 	}
+	return f
+}
 
+// MessageChain gets the message of each error in the tree top-down without the messages of the errors they wrap
+// Errors without a message of their own like from QuickWrap are skipped
+func MessageChain(err error) []string {
+	msgs := []string{}
 	iter := joinederr.NewDepthFirstIterator(err)
 	for {
 		err = iter.Next()
 		if err == nil {
-			return false
+			return msgs
 		}
 
-		fields := map[string]any{}
-		for _, fn := range fieldFuncs {
-			for k, v := range fn(err) {
-				fields[k] = v
+		var msg string
+		switch e := err.(type) {
+		case *impl:
+			msg = e.msg
+		default:
+			msg = err.Error()
+			if u := errors.Unwrap(err); u != nil {
+				msg = strings.TrimRight(strings.TrimSuffix(msg, u.Error()), " :\n")
 			}
 		}
+		if msg != "" {
+			msgs = append(msgs, msg)
+		}
+	}
+}
+
+// FieldWithDepth is a field value with the depth of the error it came from
+type FieldWithDepth struct {
+	Value any
+	Depth int
+}
+
+// FieldsWithDepth is AllFields with how deep in the tree each field was set
+// The outermost error is depth 0 and each wrapped error adds one, the branches of joined errors are at the same depth
+// The depth is of the value that AllFields reports, fields gathered as slices report the shallowest depth
+func FieldsWithDepth(err error) map[string]FieldWithDepth { return global.Load().FieldsWithDepth(err) }
+func (in Instance) FieldsWithDepth(err error) map[string]FieldWithDepth {
+	f := map[string]FieldWithDepth{}
+	for k, tf := range in.allTreeFields(err) {
+		f[k] = FieldWithDepth{Value: tf.value, Depth: tf.depth}
+	}
+	return f
+}
+
+// Redact gets all the fields of the error with the redactor applied to every value
+// Unlike field hooks this also applies to fields from errors that were not created with ctxerr
+func Redact(err error, redactor func(key string, value any) any) map[string]any {
+	return global.Load().Redact(err, redactor)
+}
+func (in Instance) Redact(err error, redactor func(key string, value any) any) map[string]any {
+	f := in.AllFields(err)
+	if redactor == nil {
+		return f
+	}
+	for k, v := range f {
+		f[k] = redactor(k, v)
+	}
+	return f
+}
 
-		if _, ok := fields[field]; ok {
+// FieldAbsent is the value in FieldsDiff for a field that one of the errors does not have
+var FieldAbsent any = absentField{}
+
+type absentField struct{}
+
+func (absentField) String() string { return "<absent>" }
+
+// FieldsDiff gets the fields from AllFields that are different between the errors as [a's value, b's value]
+// A field that only one error has uses FieldAbsent for the other value
+func FieldsDiff(a, b error) map[string][2]any { return global.Load().FieldsDiff(a, b) }
+func (in Instance) FieldsDiff(a, b error) map[string][2]any {
+	af, bf := in.AllFields(a), in.AllFields(b)
+	diff := map[string][2]any{}
+	for k, av := range af {
+		bv, ok := bf[k]
+		if !ok {
+			bv = FieldAbsent
+		}
+		if !reflect.DeepEqual(av, bv) {
+			diff[k] = [2]any{av, bv}
+		}
+	}
+	for k, bv := range bf {
+		if _, ok := af[k]; !ok {
+			diff[k] = [2]any{FieldAbsent, bv}
+		}
+	}
+	return diff
+}
+
+// HasField unwraps and checks if the error has a field in the error tree
+func HasField(err error, field string) bool { return global.Load().HasField(err, field) }
+func (in Instance) HasField(err error, field string) bool {
+	return in.walkTree(err, 0, func(err error) bool {
+		_, ok := in.errorFields(err)[field]
+		return ok
+	})
+}
+
+// ContainsErr is errors.Is checked at every error in the tree so it finds the target in any joined branch
+func ContainsErr(err, target error) bool {
+	iter := joinederr.NewDepthFirstIterator(err)
+	for {
+		err = iter.Next()
+		if err == nil {
+			return false
+		}
+		if errors.Is(err, target) {
 			return true
 		}
 	}
@@ -459,55 +1355,587 @@ func As(err error) (CtxErr, bool) {
 	return e, true
 }
 
+// Ensure returns the error if it is a CtxErr or wraps it like Wrap without a code or message so fields can be attached
+func Ensure(ctx context.Context, err error) CtxErr { return global.Load().Ensure(ctx, err) }
+func (in Instance) Ensure(ctx context.Context, err error) CtxErr {
+	if err == nil {
+		return nil
+	}
+	if ce, ok := err.(CtxErr); ok {
+		return ce
+	}
+
+	if ce, ok := in.Wrap(ctx, err, "", nil).(CtxErr); ok {
+		return ce
+	}
+	// MaxWrapDepth was reached so wrap it without the create hooks to still be able to attach fields
+	return in.newImpl(ctx, err, "")
+}
+
+// GetCode gets the code of the outermost error in the tree that has one
+func GetCode(err error) (string, bool) { return global.Load().GetCode(err) }
+func (in Instance) GetCode(err error) (string, bool) {
+	iter := joinederr.NewDepthFirstIterator(err)
+	for {
+		err = iter.Next()
+		if err == nil {
+			return "", false
+		}
+
+		if c, ok := in.errorFields(err)[in.FieldKey(FieldKeyCode)]; ok {
+			if s, ok := c.(string); ok {
+				return s, true
+			}
+			return fmt.Sprint(c), true
+		}
+	}
+}
+
+// GetAction gets the action of the outermost error in the tree that has one
+// Structured actions from SetActionValue are converted with fmt.Sprint
+func GetAction(err error) (string, bool) { return global.Load().GetAction(err) }
+func (in Instance) GetAction(err error) (string, bool) {
+	v, ok := in.outermostField(err, in.FieldKey(FieldKeyAction))
+	if !ok {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// GetStatusCode gets the status code of the outermost error in the tree that has one that is an integer
+func GetStatusCode(err error) (int, bool) { return global.Load().GetStatusCode(err) }
+func (in Instance) GetStatusCode(err error) (int, bool) {
+	v, ok := in.outermostField(err, in.FieldKey(FieldKeyStatusCode))
+	if !ok {
+		return 0, false
+	}
+	return statusCodeValue(v)
+}
+
+// statusCodeValue converts a status code field of any integer type or an integer string to an int
+func statusCodeValue(v any) (int, bool) {
+	switch rv := reflect.ValueOf(v); {
+	case rv.CanInt():
+		return int(rv.Int()), true
+	case rv.CanUint():
+		return int(rv.Uint()), true
+	}
+	sc, err := strconv.Atoi(fmt.Sprint(v))
+	return sc, err == nil
+}
+
+// HasCode checks if any error in the tree has the code
+func HasCode(err error, code string) bool { return global.Load().HasCode(err, code) }
+func (in Instance) HasCode(err error, code string) bool {
+	iter := joinederr.NewDepthFirstIterator(err)
+	for {
+		err = iter.Next()
+		if err == nil {
+			return false
+		}
+
+		if c, ok := in.errorFields(err)[in.FieldKey(FieldKeyCode)]; ok && c == code {
+			return true
+		}
+	}
+}
+
+// IsRetryable uses the outermost error in the tree with FieldKeyRetryable, false if none have it
+func IsRetryable(err error) bool { return global.Load().IsRetryable(err) }
+func (in Instance) IsRetryable(err error) bool {
+	v, _ := in.outermostField(err, in.FieldKey(FieldKeyRetryable))
+	b, _ := v.(bool)
+	return b
+}
+
+// RetryAfter gets the duration from the outermost error in the tree with FieldKeyRetryAfter
+func RetryAfter(err error) (time.Duration, bool) { return global.Load().RetryAfter(err) }
+func (in Instance) RetryAfter(err error) (time.Duration, bool) {
+	v, _ := in.outermostField(err, in.FieldKey(FieldKeyRetryAfter))
+	d, ok := v.(time.Duration)
+	return d, ok
+}
+
+// IsCode is HasCode named to read like errors.Is when asserting codes in tests
+// It is true if any layer of the tree has the code and false for errors not created with ctxerr
+func IsCode(err error, code string) bool { return global.Load().IsCode(err, code) }
+func (in Instance) IsCode(err error, code string) bool {
+	return in.HasCode(err, code)
+}
+
+// CodeError creates a target for errors.Is that matches errors with the code
+//
+//	errors.Is(err, ctxerr.CodeError("NOT_FOUND"))
+func CodeError(code string) error { return codeError{code: code} }
+
+// Sentinel creates a package level error that errors.Is matches with itself and any error in a tree with the code
+// It has no fields or location so it is safe to create at init
+//
+//	var ErrNotFound = ctxerr.Sentinel("NOT_FOUND", "resource not found")
+func Sentinel(code, message string) error { return &sentinelError{code: code, msg: message} }
+
+// Info is the common details of an error
+type Info struct {
+	Code       string
+	Category   any
+	Action     string
+	StatusCode int
+	Fields     map[string]any
+}
+
+// Inspect gets the common details from all the fields of the error
+// The bool is true if the tree has a CtxErr like As
+func Inspect(err error) (Info, bool) { return global.Load().Inspect(err) }
+func (in Instance) Inspect(err error) (Info, bool) {
+	f := in.AllFields(err)
+	info := Info{
+		Category: f[in.FieldKey(FieldKeyCategory)],
+		Fields:   f,
+	}
+	if v, ok := f[in.FieldKey(FieldKeyCode)]; ok {
+		info.Code = fmt.Sprint(v)
+	}
+	if v, ok := f[in.FieldKey(FieldKeyAction)]; ok {
+		info.Action = fmt.Sprint(v)
+	}
+	if v, ok := f[in.FieldKey(FieldKeyStatusCode)]; ok {
+		info.StatusCode, _ = statusCodeValue(v)
+	}
+
+	_, ok := As(err)
+	return info, ok
+}
+
 // HasCategory tells if an error in the chain matches the category
-func HasCategory(err error, category any) bool { return global.HasCategory(err, category) }
+// A Category matches the same value as a string
+func HasCategory(err error, category any) bool { return global.Load().HasCategory(err, category) }
 func (in Instance) HasCategory(err error, category any) bool {
-	fieldFuncs := append([]func(error) map[string]any{}, in.GetFieldsFuncs...)
-	if len(fieldFuncs) == 0 {
-		fieldFuncs = append(fieldFuncs, DefaultFieldsFunc)
+	return in.walkTree(err, 0, func(err error) bool {
+		c, ok := in.errorFields(err)[in.FieldKey(FieldKeyCategory)]
+		return ok && categoryValue(c) == categoryValue(category)
+	})
+}
+
+// Categories gets every distinct category in the error tree in the order they are found
+func Categories(err error) []any { return global.Load().Categories(err) }
+func (in Instance) Categories(err error) []any {
+	categories := []any{}
+	iter := joinederr.NewDepthFirstIterator(err)
+	for {
+		err = iter.Next()
+		if err == nil {
+			return categories
+		}
+
+		c, ok := in.errorFields(err)[in.FieldKey(FieldKeyCategory)]
+		if !ok {
+			continue
+		}
+		if !slices.ContainsFunc(categories, func(v any) bool { return reflect.DeepEqual(categoryValue(v), categoryValue(c)) }) {
+			categories = append(categories, c)
+		}
 	}
+}
+
+/* Implementation helper code */
 
+type contextKey string
+
+// outermostField gets the value of the field from the outermost error in the tree that has it
+func (in Instance) outermostField(err error, key string) (any, bool) {
 	iter := joinederr.NewDepthFirstIterator(err)
 	for {
 		err = iter.Next()
 		if err == nil {
-			return false
+			return nil, false
 		}
 
-		fields := map[string]any{}
-		for _, fn := range fieldFuncs {
-			for k, v := range fn(err) {
-				fields[k] = v
-			}
+		if v, ok := in.errorFields(err)[key]; ok {
+			return v, true
 		}
+	}
+}
+
+// unwrapDepthReached tells if walking the tree should stop at the depth, see treeFields for how depth is counted
+func (in Instance) unwrapDepthReached(depth int) bool {
+	return in.MaxUnwrapDepth > 0 && depth >= in.MaxUnwrapDepth
+}
 
-		if c, ok := fields[FieldKeyCategory]; ok {
-			if c == category {
+// walkTree calls found for the errors in the tree in the same order and until the same depth as treeFields
+// It stops and returns true when found returns true
+func (in Instance) walkTree(err error, depth int, found func(error) bool) bool {
+	if err == nil || in.unwrapDepthReached(depth) {
+		return false
+	}
+	if x, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range x.Unwrap() {
+			if in.walkTree(e, depth, found) {
 				return true
 			}
 		}
+		return false
+	}
+	if found(err) {
+		return true
 	}
+	if x, ok := err.(interface{ Unwrap() error }); ok {
+		return in.walkTree(x.Unwrap(), depth+1, found)
+	}
+	return false
 }
 
-/* Implementation helper code */
+// wrapDepthReached tells if the error already wraps MaxWrapDepth errors
+func (in Instance) wrapDepthReached(err error) bool {
+	if in.MaxWrapDepth <= 0 {
+		return false
+	}
+	depth := 0
+	for ; err != nil && depth < in.MaxWrapDepth; depth++ {
+		err = errors.Unwrap(err)
+	}
+	return depth >= in.MaxWrapDepth
+}
 
-type contextKey string
+// isSliceField tells if the key is one of FieldsAsSlice after FieldKeyMap
+func (in Instance) isSliceField(key string) bool {
+	return slices.ContainsFunc(in.FieldsAsSlice, func(k string) bool { return in.FieldKey(k) == key })
+}
+
+// categoryValue converts a Category to a string so it matches the raw string
+func categoryValue(v any) any {
+	if c, ok := v.(Category); ok {
+		return string(c)
+	}
+	return v
+}
+
+// collectedValues are values gathered from joined errors with JoinedFieldsCollect
+type collectedValues []any
+
+// treeField is a field value and how deep in the tree it was found
+type treeField struct {
+	value any
+	depth int
+}
+
+// treeFields gets the fields of the error and everything it wraps until MaxUnwrapDepth
+// Each wrapped error adds one to the depth, the branches of joined errors are at the depth of the joined error
+func (in Instance) treeFields(err error, depth int) map[string]treeField {
+	f := map[string]treeField{}
+	if err == nil || in.unwrapDepthReached(depth) {
+		return f
+	}
+
+	// Joined errors don't have fields of their own, only their branches do
+	if x, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range x.Unwrap() {
+			in.mergeFields(f, in.treeFields(e, depth), in.JoinedFields)
+		}
+		return f
+	}
+
+	for k, v := range in.errorFields(err) {
+		v = in.limitFieldValue(in.marshalFieldValue(v))
+		if in.isSliceField(k) {
+			v = []any{v}
+		}
+		f[k] = treeField{value: v, depth: depth}
+	}
+
+	if x, ok := err.(interface{ Unwrap() error }); ok {
+		code, hasCode := f[in.FieldKey(FieldKeyCode)]
+		in.mergeFields(f, in.treeFields(x.Unwrap(), depth+1), JoinedFieldsLastWins)
+		// The code of a Join is reported over the codes of its branches
+		if im, ok := err.(*impl); ok && im.shared && hasCode {
+			f[in.FieldKey(FieldKeyCode)] = code
+		}
+	}
+	return f
+}
+
+// mergeFields adds the fields from src to dst, appending slice fields and using the mode for other collisions
+// Slice fields keep the shallowest depth, other fields keep the depth of the value that wins
+func (in Instance) mergeFields(dst, src map[string]treeField, mode JoinedFieldsMode) {
+	for k, v := range src {
+		existing, ok := dst[k]
+		switch {
+		case !ok:
+			dst[k] = v
+		case in.isSliceField(k):
+			dst[k] = treeField{value: append(existing.value.([]any), v.value.([]any)...), depth: min(existing.depth, v.depth)}
+		case mode == JoinedFieldsFirstWins:
+		case mode == JoinedFieldsCollect:
+			if c := collectValues(existing.value, v.value); len(c) > 1 {
+				dst[k] = treeField{value: c, depth: min(existing.depth, v.depth)}
+			}
+		default:
+			dst[k] = v
+		}
+	}
+}
+
+// outermostFieldDepth gets the depth of the outermost error with the key walking the tree like treeFields
+func (in Instance) outermostFieldDepth(err error, key string, depth int) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+	if x, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range x.Unwrap() {
+			if d, ok := in.outermostFieldDepth(e, key, depth); ok {
+				return d, true
+			}
+		}
+		return 0, false
+	}
+	if _, ok := in.errorFields(err)[key]; ok {
+		return depth, true
+	}
+	if x, ok := err.(interface{ Unwrap() error }); ok {
+		return in.outermostFieldDepth(x.Unwrap(), key, depth+1)
+	}
+	return 0, false
+}
+
+// collectValues combines the distinct values into collectedValues
+func collectValues(a, b any) collectedValues {
+	var c collectedValues
+	for _, v := range []any{a, b} {
+		vs, ok := v.(collectedValues)
+		if !ok {
+			vs = collectedValues{v}
+		}
+		for _, v := range vs {
+			if !slices.ContainsFunc(c, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+				c = append(c, v)
+			}
+		}
+	}
+	return c
+}
+
+// marshalFieldValue converts the value with the FieldValueMarshalers for its type
+func (in Instance) marshalFieldValue(v any) any {
+	if v == nil || len(in.FieldValueMarshalers) == 0 {
+		return v
+	}
+	if f, ok := in.FieldValueMarshalers[reflect.TypeOf(v)]; ok {
+		return f(v)
+	}
+	return v
+}
+
+// limitFieldValue truncates strings and bytes over MaxFieldValueBytes and replaces other large values with a marker
+func (in Instance) limitFieldValue(v any) any {
+	max := in.MaxFieldValueBytes
+	if max <= 0 {
+		return v
+	}
+
+	switch t := v.(type) {
+	case string:
+		if len(t) <= max {
+			return t
+		}
+		n := max
+		for n > 0 && !utf8.RuneStart(t[n]) {
+			n--
+		}
+		return t[:n] + fmt.Sprintf("<truncated %d bytes>", len(t)-n)
+	case []byte:
+		if len(t) <= max {
+			return t
+		}
+		return append([]byte{}, t[:max]...)
+	}
+
+	if b, err := json.Marshal(v); err == nil && len(b) > max {
+		return fmt.Sprintf("<truncated %d bytes>", len(b))
+	}
+	return v
+}
+
+// OmitField can be returned by a field hook so the field is not set, the hooks after it do not run
+var OmitField any = omitField{}
+
+type omitField struct{}
+
+// runFieldHooks updates a field value with the field hooks before it is set
+func (in Instance) runFieldHooks(ctx context.Context, key string, value any) any {
+	for _, f := range in.FieldHooks {
+		if value = f(ctx, value); value == OmitField {
+			return value
+		}
+	}
+	for _, f := range in.FieldHooksWithKey {
+		if value = f(ctx, key, value); value == OmitField {
+			return value
+		}
+	}
+	return value
+}
+
+// runCreateHooks updates the context with the create hooks before an error is created
+func (in Instance) runCreateHooks(ctx context.Context, code string, wrapping error) context.Context {
+	if code == "" && in.DefaultCode != "" {
+		if _, ok := Fields(ctx)[in.FieldKey(FieldKeyCode)]; !ok && !in.HasField(wrapping, in.FieldKey(FieldKeyCode)) {
+			code = in.DefaultCode
+		}
+	}
+
+	if d, ok := in.CodeDefaults[code]; ok {
+		fields := Fields(ctx)
+		if _, ok := fields[in.FieldKey(FieldKeyAction)]; !ok && d.Action != "" {
+			ctx = in.SetAction(ctx, d.Action)
+		}
+		if _, ok := fields[in.FieldKey(FieldKeyStatusCode)]; !ok && d.StatusCode != 0 {
+			ctx = in.SetHTTPStatusCode(ctx, d.StatusCode)
+		}
+	}
+
+	for _, hook := range in.CreateHooks {
+		if f, ok := instanceCreateHooks[funcPC(hook)]; ok {
+			ctx = f(in, ctx, code, wrapping)
+			continue
+		}
+		ctx = hook(ctx, code, wrapping)
+	}
+	return ctx
+}
+
+// instanceCreateHooks are the package level create hooks that runCreateHooks runs with its instance so its FieldKeyMap is used
+// It is set in init because the hooks create errors which would be an initialization cycle
+var instanceCreateHooks map[uintptr]func(Instance, context.Context, string, error) context.Context
+
+// funcPC gets the code pointer of a function to compare functions that cannot be compared with ==
+func funcPC(f any) uintptr { return reflect.ValueOf(f).Pointer() }
+
+// isHelperFunc tells if the full function name should be skipped when getting the caller
+func (in Instance) isHelperFunc(name string) bool {
+	if strings.HasPrefix(filepath.Base(name), "ctxerr.") || isSubpackageFunc(name) {
+		return true
+	}
+	for _, p := range in.CallerSkipPrefixes {
+		if strings.HasPrefix(name, p) || strings.Contains(name, "/"+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubpackageFunc tells if the full function name is from a helper subpackage like ctxerr/http
+func isSubpackageFunc(name string) bool {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return false
+	}
+	pkg := name
+	if j := strings.Index(name[i:], "."); j >= 0 {
+		pkg = name[:i+j]
+	}
+	return strings.HasPrefix(pkg, modulePath+"/") && !strings.HasSuffix(pkg, "_test")
+}
+
+var modulePath = reflect.TypeOf(impl{}).PkgPath()
+
+// errorFields gets the fields of a single error using the fields funcs
+func (in Instance) errorFields(err error) map[string]any {
+	fieldFuncs := in.GetFieldsFuncs
+	if len(fieldFuncs) == 0 {
+		fieldFuncs = []func(error) map[string]any{DefaultFieldsFunc}
+	}
+
+	fields := map[string]any{}
+	for _, fn := range fieldFuncs {
+		for k, v := range fn(err) {
+			fields[k] = v
+		}
+	}
+
+	if len(in.GetFieldsFuncsCtx) == 0 {
+		return fields
+	}
+	ctx := context.Background()
+	if c, ok := err.(interface{ Context() context.Context }); ok && c.Context() != nil {
+		ctx = c.Context()
+	}
+	for _, fn := range in.GetFieldsFuncsCtx {
+		for k, v := range fn(ctx, err) {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+type codeError struct {
+	code string
+}
+
+func (ce codeError) Error() string { return "error code " + ce.code }
+
+type sentinelError struct {
+	code string
+	msg  string
+}
+
+func (se *sentinelError) Error() string {
+	if se.msg == "" {
+		return "error code " + se.code
+	}
+	return se.msg
+}
 
 type impl struct {
 	ctx     context.Context
+	fields  map[string]any // snapshot of the context fields so reads don't race with changes to the context
 	msg     string
 	wrapped error
+	sep     string
+	shared  bool   // created by Join so its code replaces the codes of the errors it wraps
+	codeKey string // key of the code in fields from the instance that created it
+	msgCode bool   // include the code in the message because IncludeCodeInError was set
+
+	allFields func(error) map[string]any // AllFields of the instance that created it for Format and MarshalJSON
+}
+
+// newImpl creates the error with the context and settings of the instance
+func (in Instance) newImpl(ctx context.Context, wrapped error, msg string) *impl {
+	return &impl{
+		ctx:     ctx,
+		fields:  FieldsCopy(ctx),
+		msg:     msg,
+		wrapped: wrapped,
+		sep:     in.MessageSeparator,
+		codeKey: in.FieldKey(FieldKeyCode),
+		msgCode: in.IncludeCodeInError,
+
+		allFields: in.AllFields,
+	}
 }
 
 // Error fulfills the error interface
 func (im *impl) Error() string {
+	msg := im.msg
+	if code, ok := im.fields[im.codeKey]; ok && im.msgCode && code != "" {
+		msg = strings.TrimSpace(fmt.Sprintf("[%v] %s", code, msg))
+	}
+
 	if u := errors.Unwrap(im); u != nil {
-		if im.msg == "" {
+		if msg == "" {
 			return u.Error()
 		}
-		return im.msg + " : " + u.Error()
+		if im.msg == "" {
+			return msg + " " + u.Error()
+		}
+		sep := im.sep
+		if sep == "" {
+			sep = DefaultMessageSeparator
+		}
+		return msg + sep + u.Error()
 	}
-	return im.msg
+	return msg
 }
 
 // Unwrap fulfills the interface to allow errors.Unwrap
@@ -520,56 +1948,274 @@ func (im *impl) As(err any) bool {
 }
 
 // Is fulfills the interface to allow errors.Is
-func (im *impl) Is(err error) bool { return im.As(err) }
+// When the target was created with CodeError or Sentinel it matches if this error has that code, errors.Is checks the rest of the tree
+func (im *impl) Is(err error) bool {
+	if ce, ok := err.(codeError); ok {
+		return im.hasCode(ce.code)
+	}
+	if se, ok := err.(*sentinelError); ok {
+		return im.hasCode(se.code)
+	}
+	return im.As(err)
+}
+
+// hasCode checks only the code of this error and not the errors it wraps
+func (im *impl) hasCode(code string) bool {
+	c, ok := im.fields[im.codeKey]
+	return ok && c == code
+}
 
 // Context retrieves the context passed in when the error was created
 func (im *impl) Context() context.Context { return im.ctx }
 
 // Fields retrieves the fields from the context passed in when the error was created
-func (im *impl) Fields() map[string]any { return Fields(im.ctx) }
+func (im *impl) Fields() map[string]any { return im.fields }
 
 // WithContext replaces the context of the error
-func (im *impl) WithContext(ctx context.Context) { im.ctx = ctx }
+// It should not be called while other goroutines are reading the error
+func (im *impl) WithContext(ctx context.Context) {
+	im.ctx = ctx
+	im.fields = FieldsCopy(ctx)
+}
+
+// Format fulfills the fmt.Formatter interface
+// The verb %+v adds all the fields of the error on separate lines after the message
+func (im *impl) Format(s fmt.State, verb rune) {
+	if verb != 'v' || !s.Flag('+') {
+		fmt.Fprintf(s, fmt.FormatString(s, verb), im.Error())
+		return
+	}
+
+	fmt.Fprint(s, im.Error())
+	f := im.allFields(im)
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := f[k]
+		if vs, ok := v.([]any); ok {
+			strs := make([]string, len(vs))
+			for i := range vs {
+				strs[i] = fmt.Sprint(vs[i])
+			}
+			v = strings.Join(strs, ", ")
+		}
+		fmt.Fprintf(s, "\n\t%s: %v", k, v)
+	}
+}
+
+// MarshalJSON fulfills the json.Marshaler interface with the message and all fields of the error
+func (im *impl) MarshalJSON() ([]byte, error) {
+	f := im.allFields(im)
+	for k, v := range f {
+		f[k] = jsonSafe(v)
+	}
+	return json.Marshal(struct {
+		Message string         `json:"message"`
+		Fields  map[string]any `json:"fields"`
+	}{
+		Message: im.Error(),
+		Fields:  f,
+	})
+}
+
+// jsonSafe replaces values that cannot be marshalled as JSON with their string form
+func jsonSafe(v any) any {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
 
 // ** Helper Functions ** //
 
 // SetHTTPStatusCode is equivelent to ctxerr.SetField(ctx, FieldKeyStatusCode, code)
 func SetHTTPStatusCode(ctx context.Context, code int) context.Context {
-	return global.SetHTTPStatusCode(ctx, code)
+	return global.Load().SetHTTPStatusCode(ctx, code)
 }
 func (in Instance) SetHTTPStatusCode(ctx context.Context, code int) context.Context {
-	return in.SetField(ctx, FieldKeyStatusCode, code)
+	return in.SetField(ctx, in.FieldKey(FieldKeyStatusCode), code)
 }
 
 // SetAction is equivelent to ctxerr.SetField(ctx, FieldKeyAction, action)
 func SetAction(ctx context.Context, action string) context.Context {
-	return global.SetAction(ctx, action)
+	return global.Load().SetAction(ctx, action)
 }
 func (in Instance) SetAction(ctx context.Context, action string) context.Context {
-	return in.SetField(ctx, FieldKeyAction, action)
+	return in.SetField(ctx, in.FieldKey(FieldKeyAction), action)
+}
+
+// SetActionValue sets a structured action like a localization key with parameters
+func SetActionValue(ctx context.Context, action any) context.Context {
+	return global.Load().SetActionValue(ctx, action)
+}
+func (in Instance) SetActionValue(ctx context.Context, action any) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyAction), action)
+}
+
+// SetLevel is equivelent to ctxerr.SetField(ctx, FieldKeyLevel, level)
+func SetLevel(ctx context.Context, level Level) context.Context {
+	return global.Load().SetLevel(ctx, level)
+}
+func (in Instance) SetLevel(ctx context.Context, level Level) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyLevel), level)
+}
+
+// GetLevel gets the level of the outermost error in the tree that has one, LevelError if none have one
+func GetLevel(err error) Level { return global.Load().GetLevel(err) }
+func (in Instance) GetLevel(err error) Level {
+	v, ok := in.outermostField(err, in.FieldKey(FieldKeyLevel))
+	if !ok {
+		return LevelError
+	}
+	if l, ok := v.(Level); ok {
+		return l
+	}
+	return Level(fmt.Sprint(v))
 }
 
 // SetCategory is equivelent to ctxerr.SetField(ctx, FieldKeyStatusCode, category)
 func SetCategory(ctx context.Context, category any) context.Context {
-	return global.SetCategory(ctx, category)
+	return global.Load().SetCategory(ctx, category)
 }
 func (in Instance) SetCategory(ctx context.Context, category any) context.Context {
-	return in.SetField(ctx, FieldKeyCategory, category)
+	return in.SetField(ctx, in.FieldKey(FieldKeyCategory), category)
+}
+
+// SetPublicMessage is equivelent to ctxerr.SetField(ctx, FieldKeyPublicMessage, message)
+func SetPublicMessage(ctx context.Context, message string) context.Context {
+	return global.Load().SetPublicMessage(ctx, message)
+}
+func (in Instance) SetPublicMessage(ctx context.Context, message string) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyPublicMessage), message)
+}
+
+// SetQuiet is equivelent to ctxerr.SetField(ctx, FieldKeyQuiet, quiet)
+func SetQuiet(ctx context.Context, quiet bool) context.Context {
+	return global.Load().SetQuiet(ctx, quiet)
+}
+func (in Instance) SetQuiet(ctx context.Context, quiet bool) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyQuiet), quiet)
+}
+
+// SetRetryable is equivelent to ctxerr.SetField(ctx, FieldKeyRetryable, retryable)
+func SetRetryable(ctx context.Context, retryable bool) context.Context {
+	return global.Load().SetRetryable(ctx, retryable)
+}
+func (in Instance) SetRetryable(ctx context.Context, retryable bool) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyRetryable), retryable)
+}
+
+// SetRetryAfter is equivelent to ctxerr.SetField(ctx, FieldKeyRetryAfter, d)
+func SetRetryAfter(ctx context.Context, d time.Duration) context.Context {
+	return global.Load().SetRetryAfter(ctx, d)
+}
+func (in Instance) SetRetryAfter(ctx context.Context, d time.Duration) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyRetryAfter), d)
 }
 
 // ** Hooks ** //
 
 // DefaultLogHook is the default hook used log errors
 // It is the fallback if there are no other handle hooks
-func DefaultLogHook(err error) { global.DefaultLogHook(err) }
+func DefaultLogHook(err error) { global.Load().DefaultLogHook(err) }
 func (in Instance) DefaultLogHook(err error) {
+	// Errors are logged without a prefix like before levels existed
+	var prefix string
+	if l := in.GetLevel(err); l != LevelError {
+		prefix = strings.ToUpper(string(l)) + " "
+	}
+
 	f := in.AllFields(err)
-	b, merr := json.Marshal(f)
+	if in.LogFormat == LogFormatLogfmt {
+		log.Printf("%s%s - %s", prefix, err, logfmt(f))
+		return
+	}
+
+	b, merr := in.encodeFields(f)
 	fields := string(b)
 	if merr != nil {
-		fields = fmt.Sprintf("fields '%v' could not be marshalled as JSON: %s", f, merr)
+		fields = fmt.Sprintf("fields '%v' could not be encoded: %s", f, merr)
 	}
-	log.Printf("%s - %s", err, fields)
+	log.Printf("%s%s - %s", prefix, err, fields)
+}
+
+// encodeFields encodes the fields with FieldEncoder or as JSON
+func (in Instance) encodeFields(f map[string]any) ([]byte, error) {
+	if in.FieldEncoder != nil {
+		return in.FieldEncoder(f)
+	}
+	return json.Marshal(f)
+}
+
+// logfmt renders fields as sorted key=value pairs
+func logfmt(f map[string]any) string {
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		v := fmt.Sprint(f[k])
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		pairs[i] = k + "=" + v
+	}
+	return strings.Join(pairs, " ")
+}
+
+// SlogHandleHook creates a handle hook that logs errors as a structured record using the logger
+// The context of the error is passed to the logger so values like trace IDs propagate
+func SlogHandleHook(logger *slog.Logger) func(error) { return slogHandleHook(global.Load, logger) }
+func (in Instance) SlogHandleHook(logger *slog.Logger) func(error) {
+	return slogHandleHook(in.pointer, logger)
+}
+func slogHandleHook(instance func() *Instance, logger *slog.Logger) func(error) {
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		in := instance()
+
+		ctx := context.Background()
+		if ce, ok := As(err); ok && ce.Context() != nil {
+			ctx = ce.Context()
+		}
+
+		f := in.AllFields(err)
+		keys := make([]string, 0, len(f))
+		for k := range f {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		attrs := make([]slog.Attr, 0, len(keys))
+		for _, k := range keys {
+			attrs = append(attrs, slog.Any(k, jsonSafe(f[k])))
+		}
+		logger.LogAttrs(ctx, slogLevel(in.GetLevel(err)), err.Error(), attrs...)
+	}
+}
+
+// pointer is like global.Load for the hooks of an instance
+func (in Instance) pointer() *Instance { return &in }
+
+// slogLevel converts the level to a slog level, unknown levels are slog.LevelError
+func slogLevel(l Level) slog.Level {
+	switch l {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
 }
 
 // DefaultFieldsFunc is the default function to get fields from an error
@@ -584,43 +2230,364 @@ func DefaultFieldsFunc(err error) map[string]any {
 
 // SetCodeHook takes the code and adds it to the context
 func SetCodeHook(ctx context.Context, code string, wrapping error) context.Context {
-	return global.SetCodeHook(ctx, code, wrapping)
+	return global.Load().SetCodeHook(ctx, code, wrapping)
 }
 func (in Instance) SetCodeHook(ctx context.Context, code string, wrapping error) context.Context {
 	if code != "" {
-		ctx = SetField(ctx, FieldKeyCode, code)
+		ctx = in.SetField(ctx, in.FieldKey(FieldKeyCode), code)
 	}
 	return ctx
 }
 
 // SetLocationHook get the location of where the error happened and adds it to the context
 func SetLocationHook(ctx context.Context, code string, wrapping error) context.Context {
-	return global.SetLocationHook(ctx, code, wrapping)
+	return global.Load().SetLocationHook(ctx, code, wrapping)
 }
 func (in Instance) SetLocationHook(ctx context.Context, code string, wrapping error) context.Context {
-	ctx = SetField(ctx, FieldKeyLocation, CallerFunc(2))
+	ctx = in.SetField(ctx, in.FieldKey(FieldKeyLocation), in.CallerFunc(2))
 	return ctx
 }
 
+// SetDetailedLocationHook is like SetLocationHook but includes the file and line as "file:line:function"
+// Replace SetLocationHook in the instance's CreateHooks with it to use it
+func SetDetailedLocationHook(ctx context.Context, code string, wrapping error) context.Context {
+	return global.Load().SetDetailedLocationHook(ctx, code, wrapping)
+}
+func (in Instance) SetDetailedLocationHook(ctx context.Context, code string, wrapping error) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyLocation), in.CallerLocation(2))
+}
+
+// JSONHandleHook creates a handle hook that writes each error as a line of JSON with the time, message, and fields
+// It is safe to use from multiple goroutines, use AddHandleHook to enable it
+func JSONHandleHook(w io.Writer) func(error) { return jsonHandleHook(global.Load, w) }
+func (in Instance) JSONHandleHook(w io.Writer) func(error) {
+	return jsonHandleHook(in.pointer, w)
+}
+func jsonHandleHook(instance func() *Instance, w io.Writer) func(error) {
+	var mu sync.Mutex
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		in := instance()
+		now := in.Now
+		if now == nil {
+			now = time.Now
+		}
+
+		f := in.AllFields(err)
+		for k, v := range f {
+			f[k] = jsonSafe(v)
+		}
+		f["message"] = err.Error()
+		f["time"] = now()
+		b, eerr := in.encodeFields(f)
+		if eerr != nil {
+			b = []byte(fmt.Sprintf("fields '%v' could not be encoded: %s", f, eerr))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(b, '\n'))
+	}
+}
+
+// RateLimitedHandleHook wraps a handle hook so each key is only handled perKey times per window
+// The next error handled after some were skipped gets the field FieldKeySuppressedCount, a nil keyFn uses the code
+// Keys not seen for a whole window are forgotten along with their suppressed count
+func RateLimitedHandleHook(inner func(error), perKey int, window time.Duration, keyFn func(error) string) func(error) {
+	return rateLimitedHandleHook(global.Load, inner, perKey, window, keyFn)
+}
+func (in Instance) RateLimitedHandleHook(inner func(error), perKey int, window time.Duration, keyFn func(error) string) func(error) {
+	return rateLimitedHandleHook(in.pointer, inner, perKey, window, keyFn)
+}
+func rateLimitedHandleHook(instance func() *Instance, inner func(error), perKey int, window time.Duration, keyFn func(error) string) func(error) {
+	type limit struct {
+		start      time.Time
+		count      int
+		suppressed int
+	}
+	var mu sync.Mutex
+	limits := map[string]*limit{}
+	var swept time.Time
+
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		in := instance()
+
+		var key string
+		if keyFn != nil {
+			key = keyFn(err)
+		} else {
+			key, _ = in.GetCode(err)
+		}
+		t := time.Now()
+		if in.Now != nil {
+			t = in.Now()
+		}
+		mu.Lock()
+		// Once a window remove keys not seen for a whole window so the map does not grow without bound
+		if t.Sub(swept) >= window {
+			for k, l := range limits {
+				if t.Sub(l.start) >= 2*window {
+					delete(limits, k)
+				}
+			}
+			swept = t
+		}
+		l, ok := limits[key]
+		if !ok {
+			l = &limit{start: t}
+			limits[key] = l
+		}
+		if t.Sub(l.start) >= window {
+			l.start, l.count = t, 0
+		}
+		if l.count >= perKey {
+			l.suppressed++
+			mu.Unlock()
+			return
+		}
+		l.count++
+		suppressed := l.suppressed
+		l.suppressed = 0
+		mu.Unlock()
+
+		if suppressed > 0 {
+			ctx := in.SetField(context.Background(), in.FieldKey(FieldKeySuppressedCount), suppressed)
+			err = in.newImpl(ctx, err, "")
+		}
+		inner(err)
+	}
+}
+
+// SampledHandleHook wraps a handle hook so only a fraction of errors are handled
+func SampledHandleHook(inner func(error), fraction float64) func(error) {
+	return sampledHandleHook(global.Load, inner, fraction)
+}
+func (in Instance) SampledHandleHook(inner func(error), fraction float64) func(error) {
+	return sampledHandleHook(in.pointer, inner, fraction)
+}
+func sampledHandleHook(instance func() *Instance, inner func(error), fraction float64) func(error) {
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		random := instance().Rand
+		if random == nil {
+			random = rand.Float64
+		}
+		if random() < fraction {
+			inner(err)
+		}
+	}
+}
+
+// CodeSampledHandleHook wraps a handle hook so only a fraction of codes are handled
+// The hash of the code decides so an error with the same code is always or never handled
+func CodeSampledHandleHook(inner func(error), fraction float64) func(error) {
+	return codeSampledHandleHook(global.Load, inner, fraction)
+}
+func (in Instance) CodeSampledHandleHook(inner func(error), fraction float64) func(error) {
+	return codeSampledHandleHook(in.pointer, inner, fraction)
+}
+func codeSampledHandleHook(instance func() *Instance, inner func(error), fraction float64) func(error) {
+	return func(err error) {
+		if err == nil {
+			return
+		}
+		code, _ := instance().GetCode(err)
+		h := fnv.New64a()
+		h.Write([]byte(code))
+		if float64(h.Sum64()%10000)/10000 < fraction {
+			inner(err)
+		}
+	}
+}
+
+// PerLeafHandleHook wraps a handle hook so each leaf of joined errors is handled on its own with its own fields
+// CtxErrs wrapping the joined errors are kept around each leaf, errors that are not joined are handled once
+func PerLeafHandleHook(inner func(error)) func(error) {
+	return func(err error) {
+		for _, leaf := range leafErrors(err) {
+			inner(leaf)
+		}
+	}
+}
+
+// leafErrors splits joined errors anywhere in the chain into errors that do not join others
+func leafErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if x, ok := err.(interface{ Unwrap() []error }); ok {
+		var leaves []error
+		for _, e := range x.Unwrap() {
+			leaves = append(leaves, leafErrors(e)...)
+		}
+		return leaves
+	}
+
+	leaves := leafErrors(errors.Unwrap(err))
+	if len(leaves) <= 1 {
+		return []error{err}
+	}
+	if im, ok := err.(*impl); ok {
+		for i, leaf := range leaves {
+			c := *im
+			c.wrapped = leaf
+			leaves[i] = &c
+		}
+	}
+	return leaves
+}
+
+// CodeUnregistered is the code of the error handled by ValidateRegisteredCodeHook
+const CodeUnregistered = "ctxerr_unregistered_code"
+
+// ValidateRegisteredCodeHook handles an error when a code that was not registered with RegisterCode is used
+// If PanicOnUnregisteredCode is set it panics instead, use AddCreateHook to enable it
+func ValidateRegisteredCodeHook(ctx context.Context, code string, wrapping error) context.Context {
+	return global.Load().ValidateRegisteredCodeHook(ctx, code, wrapping)
+}
+func (in Instance) ValidateRegisteredCodeHook(ctx context.Context, code string, wrapping error) context.Context {
+	if code == "" || code == CodeUnregistered {
+		return ctx
+	}
+	if _, ok := in.Codes[code]; ok {
+		return ctx
+	}
+
+	if in.PanicOnUnregisteredCode {
+		panic(fmt.Sprintf("ctxerr: code %q was not registered", code))
+	}
+	uctx := in.SetField(context.Background(), in.FieldKey(FieldKeyUnregisteredCode), code)
+	in.Handle(in.New(uctx, CodeUnregistered, "code was not registered"))
+	return ctx
+}
+
+// SetStackHook gets the stack trace of where the error happened and adds it to the context
+// It is not added by default, use AddCreateHook to enable it
+func SetStackHook(ctx context.Context, code string, wrapping error) context.Context {
+	return global.Load().SetStackHook(ctx, code, wrapping)
+}
+func (in Instance) SetStackHook(ctx context.Context, code string, wrapping error) context.Context {
+	return in.SetField(ctx, in.FieldKey(FieldKeyStack), in.CallerStack(1))
+}
+
+// SetCancellationCategoryHook sets a category when wrapping context.Canceled or context.DeadlineExceeded
+// A category already on the context or wrapped error is kept, use AddCreateHook to enable it
+func SetCancellationCategoryHook(ctx context.Context, code string, wrapping error) context.Context {
+	return global.Load().SetCancellationCategoryHook(ctx, code, wrapping)
+}
+func (in Instance) SetCancellationCategoryHook(ctx context.Context, code string, wrapping error) context.Context {
+	if wrapping == nil {
+		return ctx
+	}
+	if _, ok := Fields(ctx)[in.FieldKey(FieldKeyCategory)]; ok || in.HasField(wrapping, in.FieldKey(FieldKeyCategory)) {
+		return ctx
+	}
+
+	switch {
+	case errors.Is(wrapping, context.Canceled):
+		ctx = in.SetCategory(ctx, CategoryCanceled)
+	case errors.Is(wrapping, context.DeadlineExceeded):
+		ctx = in.SetCategory(ctx, CategoryDeadlineExceeded)
+	}
+	return ctx
+}
+
+// OffloadLargeFields creates a field hook that replaces values larger than threshold bytes with the reference from store
+// Size is the length of strings and bytes or the JSON of other values, the value is kept if store returns an error
+//
+//	ctxerr.AddFieldHook(ctxerr.OffloadLargeFields(4096, uploadBlob))
+func OffloadLargeFields(threshold int, store func(any) (string, error)) func(context.Context, any) any {
+	return func(ctx context.Context, v any) any {
+		if fieldValueSize(v) <= threshold {
+			return v
+		}
+		ref, err := store(v)
+		if err != nil {
+			return v
+		}
+		return ref
+	}
+}
+
+// fieldValueSize is the length of strings and bytes or the JSON of other values, 0 if it cannot be marshalled
+func fieldValueSize(v any) int {
+	switch t := v.(type) {
+	case string:
+		return len(t)
+	case []byte:
+		return len(t)
+	}
+	b, _ := json.Marshal(v)
+	return len(b)
+}
+
+// SetFromContextHook creates a create hook that copies the value of srcKey on the context to the field fieldKey
+// Use it for values like request IDs that are set on the context by other packages, use AddCreateHook to enable it
+func SetFromContextHook(srcKey any, fieldKey string) func(ctx context.Context, code string, wrapping error) context.Context {
+	return global.Load().SetFromContextHook(srcKey, fieldKey)
+}
+func (in Instance) SetFromContextHook(srcKey any, fieldKey string) func(ctx context.Context, code string, wrapping error) context.Context {
+	return func(ctx context.Context, code string, wrapping error) context.Context {
+		if v := ctx.Value(srcKey); v != nil {
+			ctx = in.SetField(ctx, fieldKey, v)
+		}
+		return ctx
+	}
+}
+
 /* HTTP helper function */
 
 // NewHTTP creates a new error with action and status code
 func NewHTTP(ctx context.Context, code, action string, statusCode int, message ...any) error {
-	return global.NewHTTP(ctx, code, action, statusCode, message...)
+	return global.Load().NewHTTP(ctx, code, action, statusCode, message...)
 }
 func (in Instance) NewHTTP(ctx context.Context, code, action string, statusCode int, message ...any) error {
 	if action != "" {
-		ctx = SetAction(ctx, action)
+		ctx = in.SetAction(ctx, action)
 	}
 	if statusCode != 0 {
-		ctx = SetHTTPStatusCode(ctx, statusCode)
+		ctx = in.SetHTTPStatusCode(ctx, statusCode)
 	}
 	return in.New(ctx, code, message...)
 }
 
+// NewHTTPStatus creates a new error with the status code and a code derived from its text like "not_found"
+// A code already on the context is kept
+func NewHTTPStatus(ctx context.Context, statusCode int, message ...any) error {
+	return global.Load().NewHTTPStatus(ctx, statusCode, message...)
+}
+func (in Instance) NewHTTPStatus(ctx context.Context, statusCode int, message ...any) error {
+	var code string
+	if _, ok := Fields(ctx)[in.FieldKey(FieldKeyCode)]; !ok {
+		code = statusCodeSlug(statusCode)
+	}
+	return in.NewHTTP(ctx, code, "", statusCode, message...)
+}
+
+// statusCodeSlug converts the text of a HTTP status code to a code like "not_found"
+func statusCodeSlug(statusCode int) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(http.StatusText(statusCode)), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '\'')
+	}) {
+		if b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteString(strings.ReplaceAll(word, "'", ""))
+	}
+	return b.String()
+}
+
 // NewHTTPf creates a new error  with action and status code and message formatting
 func NewHTTPf(ctx context.Context, code, action string, statusCode int, message string, messageArgs ...any) error {
-	return global.NewHTTPf(ctx, code, action, statusCode, message, messageArgs...)
+	return global.Load().NewHTTPf(ctx, code, action, statusCode, message, messageArgs...)
 }
 func (in Instance) NewHTTPf(ctx context.Context, code, action string, statusCode int, message string, messageArgs ...any) error {
 	if action != "" {
@@ -634,7 +2601,7 @@ func (in Instance) NewHTTPf(ctx context.Context, code, action string, statusCode
 
 // WrapHTTP creates a new error with action and status code and another wrapped under it
 func WrapHTTP(ctx context.Context, err error, code, action string, statusCode int, message ...any) error {
-	return global.WrapHTTP(ctx, err, code, action, statusCode, message...)
+	return global.Load().WrapHTTP(ctx, err, code, action, statusCode, message...)
 }
 func (in Instance) WrapHTTP(ctx context.Context, err error, code, action string, statusCode int, message ...any) error {
 	if action != "" {
@@ -648,7 +2615,7 @@ func (in Instance) WrapHTTP(ctx context.Context, err error, code, action string,
 
 // WrapHTTPf creates a new error with action and status code and a formatted message with another wrapped under it
 func WrapHTTPf(ctx context.Context, err error, code, action string, statusCode int, message string, messageArgs ...any) error {
-	return global.WrapHTTPf(ctx, err, code, action, statusCode, message, messageArgs...)
+	return global.Load().WrapHTTPf(ctx, err, code, action, statusCode, message, messageArgs...)
 }
 func (in Instance) WrapHTTPf(ctx context.Context, err error, code, action string, statusCode int, message string, messageArgs ...any) error {
 	if action != "" {
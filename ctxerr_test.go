@@ -1,15 +1,28 @@
 package ctxerr_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"os"
 	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mvndaai/ctxerr"
 )
@@ -103,6 +116,20 @@ func TestNil(t *testing.T) {
 		var in *ctxerr.Instance
 		in.AddFieldsFunc(func(error) map[string]any { return nil })
 	}()
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				if !strings.HasSuffix(fmt.Sprint(r), "ctxerr.Instance is nil") {
+					t.Error("recovered with wrong message:", r)
+				}
+			} else {
+				t.Error("expected to recover")
+			}
+		}()
+		var in *ctxerr.Instance
+		in.AddFieldHookWithKey(func(_ context.Context, _ string, v any) any { return v })
+	}()
 }
 
 func TestOverall(t *testing.T) {
@@ -319,6 +346,81 @@ func TestQuickWrap(t *testing.T) {
 	}
 }
 
+func TestMessageSeparator(t *testing.T) {
+	ctx := context.Background()
+
+	in := ctxerr.NewInstance()
+	err := in.Wrap(ctx, in.New(ctx, "", "inner"), "", "outer")
+	if msg := err.Error(); msg != "outer : inner" {
+		t.Error("default separator did not match", msg)
+	}
+
+	in.MessageSeparator = ": "
+	err = in.Wrap(ctx, in.New(ctx, "", "inner"), "", "outer")
+	err = in.Wrapf(ctx, err, "", "%s", "top")
+	if msg := err.Error(); msg != "top: outer: inner" {
+		t.Error("custom separator did not match", msg)
+	}
+
+	// Each error uses the separator of the instance that created it
+	err = ctxerr.Wrap(ctx, in.Wrap(ctx, errors.New("inner"), "", "middle"), "", "outer")
+	if msg := err.Error(); msg != "outer : middle: inner" {
+		t.Error("mixed separators did not match", msg)
+	}
+}
+
+func TestQuickWrapUsesCallerName(t *testing.T) {
+	ctx := context.Background()
+	in := ctxerr.NewInstance()
+
+	err := in.QuickWrap(ctx, errors.New("inner"))
+	if msg := err.Error(); msg != "inner" {
+		t.Error("message should not change by default", msg)
+	}
+
+	in.QuickWrapUsesCallerName = true
+	err = in.QuickWrap(ctx, errors.New("inner"))
+	if msg, expected := err.Error(), "ctxerr_test.TestQuickWrapUsesCallerName : inner"; msg != expected {
+		t.Errorf("message did not match\n%s\n%s", msg, expected)
+	}
+
+	if err := in.QuickWrap(ctx, nil); err != nil {
+		t.Error("wrapping nil should be nil", err)
+	}
+}
+
+func TestFieldsFuncs(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.AddFieldHook(RedactItem)
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+
+	err := in.NewFields(ctx, "INNER", map[string]any{"b": "b", "secret": redactable("s")}, "inner")
+	err = in.WrapFields(ctx, err, "OUTER", map[string]any{"c": "c"}, "outer")
+
+	f := in.AllFields(err)
+	expected := map[string]any{
+		"a":                     "a",
+		"b":                     "b",
+		"c":                     "c",
+		"secret":                "redacted",
+		ctxerr.FieldKeyCode:     "INNER",
+		ctxerr.FieldKeyLocation: []any{"ctxerr_test.TestFieldsFuncs", "ctxerr_test.TestFieldsFuncs"},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expected)
+	}
+	if msg := err.Error(); msg != "outer : inner" {
+		t.Error("message did not match", msg)
+	}
+
+	if err := ctxerr.WrapFields(ctx, nil, "code", map[string]any{"c": "c"}); err != nil {
+		t.Error("wrapping nil should be nil", err)
+	}
+	if f := ctxerr.AllFields(ctxerr.NewFields(ctx, "", nil)); f["a"] != "a" {
+		t.Error("nil fields should keep the context fields", f)
+	}
+}
+
 func TestCallerFunc(t *testing.T) {
 	cf := ctxerr.CallerFunc(0)
 	expected := "ctxerr_test.TestCallerFunc"
@@ -337,6 +439,34 @@ func TestCallerFunc(t *testing.T) {
 	}
 }
 
+func TestCallerLocation(t *testing.T) {
+	loc := ctxerr.CallerLocation(0)
+	parts := strings.Split(loc, ":")
+	if len(parts) != 3 || parts[0] != "ctxerr_test.go" || parts[2] != "ctxerr_test.TestCallerLocation" {
+		t.Error("location format did not match", loc)
+	}
+	if _, err := strconv.Atoi(parts[1]); err != nil {
+		t.Error("line was not a number", loc)
+	}
+
+	in := ctxerr.NewInstance()
+	in.CreateHooks = []func(context.Context, string, error) context.Context{in.SetCodeHook, in.SetDetailedLocationHook}
+	ctx := context.Background()
+	err1 := in.New(ctx, "code", "msg")
+	err2 := in.New(ctx, "code", "msg")
+
+	loc1 := in.AllFields(err1)[ctxerr.FieldKeyLocation].([]any)[0]
+	loc2 := in.AllFields(err2)[ctxerr.FieldKeyLocation].([]any)[0]
+	if loc1 == loc2 {
+		t.Error("locations on different lines should not match", loc1, loc2)
+	}
+	for _, l := range []any{loc1, loc2} {
+		if s := fmt.Sprint(l); !strings.HasPrefix(s, "ctxerr_test.go:") || !strings.HasSuffix(s, ":ctxerr_test.TestCallerLocation") {
+			t.Error("location did not match", s)
+		}
+	}
+}
+
 func testCallerA(skip, depth int) []string { return ctxerr.CallerFuncs(skip, depth) }
 func testCallerB(skip, depth int) []string { return testCallerA(skip, depth) }
 func testCallerC(skip, depth int) []string { return testCallerB(skip, depth) }
@@ -450,6 +580,41 @@ func TestLocation(t *testing.T) {
 	}
 }
 
+func TestStack(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.AddCreateHook(in.SetStackHook)
+
+	ctx := context.Background()
+	err := in.New(ctx, "code", "msg")
+	err = in.Wrap(ctx, err, "", "wrap")
+
+	stacks, ok := in.AllFields(err)[ctxerr.FieldKeyStack].([]any)
+	if !ok || len(stacks) != 2 {
+		t.Fatal("expected a stack for each error", in.AllFields(err)[ctxerr.FieldKeyStack])
+	}
+
+	for _, s := range stacks {
+		stack := s.([]string)
+		if len(stack) == 0 {
+			t.Fatal("stack was empty")
+		}
+		if !strings.HasPrefix(stack[0], "ctxerr_test.go:") || !strings.HasSuffix(stack[0], ":ctxerr_test.TestStack") {
+			t.Error("top of stack did not match", stack[0])
+		}
+		for _, frame := range stack {
+			if strings.Contains(frame, ":ctxerr.") {
+				t.Error("stack should not contain package frames", stack)
+			}
+		}
+	}
+
+	// The stack hook is opt in
+	err = ctxerr.New(ctx, "code", "msg")
+	if ctxerr.HasField(err, ctxerr.FieldKeyStack) {
+		t.Error("stack should not be added by default")
+	}
+}
+
 func TestDefaultLogNonJSONFields(t *testing.T) {
 	ctx := ctxerr.SetField(context.Background(), "foo", func() {})
 	err := ctxerr.New(ctx, "", "")
@@ -467,6 +632,116 @@ func TestDefaultLogNonJSONFields(t *testing.T) {
 	}
 }
 
+func TestLogFormat(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "foo", "bar baz")
+	ctx = ctxerr.SetField(ctx, "num", 1)
+	err := ctxerr.New(ctx, "code", "msg")
+
+	tests := []struct {
+		name     string
+		format   ctxerr.LogFormat
+		expected string
+	}{
+		{
+			name:     "json",
+			format:   ctxerr.LogFormatJSON,
+			expected: `msg - {"error_code":"code","error_location":["ctxerr_test.TestLogFormat"],"foo":"bar baz","num":1}`,
+		},
+		{
+			name:     "logfmt",
+			format:   ctxerr.LogFormatLogfmt,
+			expected: `msg - error_code=code error_location=[ctxerr_test.TestLogFormat] foo="bar baz" num=1`,
+		},
+	}
+
+	sb := &strings.Builder{}
+	log.SetOutput(sb)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(flags)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb.Reset()
+			in := ctxerr.NewInstance()
+			in.LogFormat = tt.format
+			in.DefaultLogHook(err)
+
+			if out := strings.TrimSpace(sb.String()); out != tt.expected {
+				t.Errorf("Logs did not match\n%s\n%s", out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSlogHandleHook(t *testing.T) {
+	type traceKey struct{}
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace")
+	ctx = ctxerr.SetField(ctx, "foo", "bar")
+	ctx = ctxerr.SetField(ctx, "fn", func() {})
+	err := ctxerr.New(ctx, "code", "msg")
+
+	var traceID any
+	buf := &bytes.Buffer{}
+	h := slogContextHandler{
+		Handler: slog.NewJSONHandler(buf, nil),
+		fn:      func(ctx context.Context) { traceID = ctx.Value(traceKey{}) },
+	}
+	ctxerr.SlogHandleHook(slog.New(h))(err)
+
+	if traceID != "trace" {
+		t.Error("context was not passed to the logger", traceID)
+	}
+
+	m := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal("log was not JSON", err, buf.String())
+	}
+	if m["level"] != "ERROR" {
+		t.Error("level did not match", m["level"])
+	}
+	if m["msg"] != "msg" {
+		t.Error("msg did not match", m["msg"])
+	}
+	if m[ctxerr.FieldKeyCode] != "code" {
+		t.Error("code did not match", m[ctxerr.FieldKeyCode])
+	}
+	if m["foo"] != "bar" {
+		t.Error("field did not match", m["foo"])
+	}
+	if v, ok := m["fn"].(string); !ok || !strings.HasPrefix(v, "0x") {
+		t.Error("non JSON field should have been stringified", m["fn"])
+	}
+
+	// Non ctxerr errors still log
+	buf.Reset()
+	ctxerr.SlogHandleHook(slog.New(h))(errors.New("plain"))
+	if !strings.Contains(buf.String(), `"msg":"plain"`) {
+		t.Error("plain error was not logged", buf.String())
+	}
+
+	// The global is read when the hook runs
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	buf.Reset()
+	hook := ctxerr.SlogHandleHook(slog.New(h))
+	ctxerr.AddFieldsFunc(func(error) map[string]any { return map[string]any{"added": "later"} })
+	hook(err)
+	if !strings.Contains(buf.String(), `"added":"later"`) {
+		t.Error("changes to the global after creating the hook were not used", buf.String())
+	}
+}
+
+type slogContextHandler struct {
+	slog.Handler
+	fn func(context.Context)
+}
+
+func (h slogContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.fn(ctx)
+	return h.Handler.Handle(ctx, r)
+}
+
 func TestCategory(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -545,6 +820,82 @@ func TestCategory(t *testing.T) {
 	}
 }
 
+func TestCategories(t *testing.T) {
+	if c := ctxerr.Categories(nil); len(c) != 0 {
+		t.Error("expected no categories", c)
+	}
+	if c := ctxerr.Categories(errors.New("a")); len(c) != 0 {
+		t.Error("expected no categories", c)
+	}
+
+	ctx := context.Background()
+	a := ctxerr.New(ctxerr.SetCategory(ctx, "retryable"), "A", "a")
+	b := ctxerr.New(ctxerr.SetCategory(ctx, "fatal"), "B", "b")
+	b = ctxerr.QuickWrap(ctxerr.SetCategory(ctx, "fatal"), b)
+	err := ctxerr.Wrap(ctxerr.SetCategory(ctx, "validation"), errors.Join(a, b), "C", "c")
+
+	expected := []any{"validation", "retryable", "fatal"}
+	if c := ctxerr.Categories(err); !reflect.DeepEqual(c, expected) {
+		t.Errorf("categories did not match\n%v\n%v", c, expected)
+	}
+}
+
+func TestTypedCategory(t *testing.T) {
+	ctx := context.Background()
+	typed := ctxerr.New(ctxerr.SetCategory(ctx, ctxerr.CategoryNotFound), "code")
+	raw := ctxerr.New(ctxerr.SetCategory(ctx, "not_found"), "code")
+
+	for _, err := range []error{typed, raw} {
+		if !ctxerr.HasCategory(err, ctxerr.CategoryNotFound) {
+			t.Error("typed category should match", ctxerr.Categories(err))
+		}
+		if !ctxerr.HasCategory(err, "not_found") {
+			t.Error("raw category should match", ctxerr.Categories(err))
+		}
+		if ctxerr.HasCategory(err, ctxerr.CategoryConflict) || ctxerr.HasCategory(err, "not_founds") {
+			t.Error("other categories should not match", ctxerr.Categories(err))
+		}
+	}
+
+	joined := errors.Join(typed, raw, ctxerr.New(ctxerr.SetCategory(ctx, ctxerr.CategoryInternal), "code"))
+	if c := ctxerr.Categories(joined); !reflect.DeepEqual(c, []any{ctxerr.CategoryNotFound, ctxerr.CategoryInternal}) {
+		t.Error("typed and raw categories should be the same", c)
+	}
+}
+
+func TestSetCancellationCategoryHook(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.AddCreateHook(in.SetCancellationCategoryHook)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		err      error
+		category any
+	}{
+		{name: "canceled", err: in.Wrap(ctx, context.Canceled, "code"), category: ctxerr.CategoryCanceled},
+		{name: "deadline", err: in.QuickWrap(ctx, context.DeadlineExceeded), category: ctxerr.CategoryDeadlineExceeded},
+		{name: "nested", err: in.QuickWrap(ctx, fmt.Errorf("call : %w", context.Canceled)), category: ctxerr.CategoryCanceled},
+		{name: "existing context category", err: in.QuickWrap(ctxerr.SetCategory(ctx, "other"), context.Canceled), category: "other"},
+		{name: "existing wrapped category", err: in.QuickWrap(ctx, ctxerr.Wrap(ctxerr.SetCategory(ctx, "other"), context.Canceled, "")), category: "other"},
+		{name: "other error", err: in.QuickWrap(ctx, errors.New("e")), category: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c := ctxerr.Categories(tt.err); tt.category == nil && len(c) > 0 {
+				t.Error("expected no categories", c)
+			}
+			if tt.category != nil && !ctxerr.HasCategory(tt.err, tt.category) {
+				t.Error("missing category", ctxerr.Categories(tt.err))
+			}
+			if c := ctxerr.Categories(tt.err); len(c) > 1 {
+				t.Error("expected only one category", c)
+			}
+		})
+	}
+}
+
 type testContextKey string
 
 func TestAddingToContext(t *testing.T) {
@@ -583,119 +934,612 @@ func TestAddingToContext(t *testing.T) {
 	}
 }
 
-func TestAllFields(t *testing.T) {
-	if f := ctxerr.AllFields(nil); f == nil {
-		t.Error("fields shouldn't have been nil")
-	}
-	if f := ctxerr.AllFields(errors.New("a")); len(f) != 0 {
-		t.Error("fields without a context should have no values")
-	}
+func TestFieldBuilder(t *testing.T) {
+	in := ctxerr.Instance{}
+	in.AddFieldHook(RedactItem)
 
-	ctx := context.Background()
-	ctx = ctxerr.SetField(ctx, "a", "a")
-	err := ctxerr.New(ctx, "a", "a")
+	ctx := in.SetField(context.Background(), "a", "a")
+	fb := in.NewFieldBuilder(ctx).
+		Set("b", "b").
+		Set("secret", redactable("secret")).
+		SetAll(map[string]any{"c": "c", "d": redactable("d")})
+	bctx := fb.Context()
 
-	ctx = ctxerr.SetField(ctx, "b", "b")
-	err = ctxerr.QuickWrap(ctx, err)
+	expected := map[string]any{"a": "a", "b": "b", "c": "c", "secret": "redacted", "d": "redacted"}
+	if f := ctxerr.Fields(bctx); !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields did not match\n%v\n%v", f, expected)
+	}
+	if f := ctxerr.Fields(ctx); len(f) != 1 {
+		t.Error("original context should not have changed", f)
+	}
 
-	fields := ctxerr.AllFields(err)
-	b, ok := fields["b"]
-	if !ok {
-		t.Fatal("b was not found in fields")
+	// Continuing to use the builder doesn't change contexts already created
+	fb.Set("e", "e")
+	if _, ok := ctxerr.Fields(bctx)["e"]; ok {
+		t.Error("built context should not have changed")
 	}
-	if b != "b" {
-		t.Error("b value did not match", b)
+	if _, ok := ctxerr.Fields(fb.Context())["e"]; !ok {
+		t.Error("new context should have the field")
 	}
 }
 
-func TestAs(t *testing.T) {
-	tests := []struct {
-		in  error
-		out bool
-	}{
-		{in: nil, out: false},
-		{in: errors.New("errors"), out: false},
-		{in: ctxerr.New(context.Background(), "-", "ctxerr"), out: true},
+func BenchmarkSetField(b *testing.B) {
+	for range b.N {
+		ctx := context.Background()
+		for i := range 15 {
+			ctx = ctxerr.SetField(ctx, fmt.Sprint(i), i)
+		}
 	}
+}
 
-	for _, tt := range tests {
-		t.Run(fmt.Sprintln(tt.in), func(t *testing.T) {
-			if _, b := ctxerr.As(tt.in); b != tt.out {
-				t.Error("bool did not match")
-			}
-		})
+func BenchmarkFieldBuilder(b *testing.B) {
+	for range b.N {
+		fb := ctxerr.NewFieldBuilder(context.Background())
+		for i := range 15 {
+			fb.Set(fmt.Sprint(i), i)
+		}
+		_ = fb.Context()
 	}
+}
 
+func BenchmarkNewNoFields(b *testing.B) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	for range b.N {
+		_ = ctxerr.New(ctx, "", "msg")
+	}
 }
 
-func TestFallbackHandle(t *testing.T) {
-	tests := []struct {
-		name        string
-		HandleHooks []func(error)
-		expectedLog bool
-	}{
-		{name: "fallback",
-			HandleHooks: nil,
-			expectedLog: true,
-		},
-		{name: "normal",
-			HandleHooks: []func(error){func(error) {}},
-			expectedLog: false,
-		},
+func BenchmarkIsCodeErrorDeepChain(b *testing.B) {
+	ctx := context.Background()
+	err := ctxerr.New(ctx, "INNER")
+	for range 1000 {
+		err = ctxerr.Wrap(ctx, err, "WRAP")
+	}
+	target := ctxerr.CodeError("INNER")
+	b.ResetTimer()
+	for range b.N {
+		_ = errors.Is(err, target)
 	}
+}
 
-	err := ctxerr.New(context.Background(), "", "msg")
-	sb := &strings.Builder{}
-	log.SetOutput(sb)
+func TestFieldsCopy(t *testing.T) {
+	if f := ctxerr.FieldsCopy(context.Background()); f != nil {
+		t.Error("expected nil without fields", f)
+	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			sb.Reset()
-			in := ctxerr.NewInstance()
-			in.HandleHooks = tt.HandleHooks
-			in.Handle(err)
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	f := ctxerr.FieldsCopy(ctx)
+	if !reflect.DeepEqual(f, ctxerr.Fields(ctx)) {
+		t.Error("copy did not match", f)
+	}
 
-			msg := sb.String()
-			if (len(sb.String()) == 0) == tt.expectedLog {
-				t.Error("expectedLog did not match:", msg)
-			}
-		})
+	f["a"] = "changed"
+	f["b"] = "b"
+	if v := ctxerr.Fields(ctx); len(v) != 1 || v["a"] != "a" {
+		t.Error("modifying the copy changed the context", v)
 	}
 }
 
-func TestCustomizations(t *testing.T) {
-	var createHookRan bool
-	var handleHookRan bool
+func TestContextFromError(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "inner", "inner")
+	err := ctxerr.New(ctx, "INNER", "inner")
+	ctx = ctxerr.SetField(context.Background(), "outer", "outer")
+	err = ctxerr.Wrap(ctx, err, "OUTER", "outer")
 
-	ctxerr.AddCreateHook(func(ctx context.Context, _ string, _ error) context.Context {
-		createHookRan = true
-		return ctx
-	})
+	f := ctxerr.Fields(ctxerr.ContextFromError(err))
+	if f["inner"] != "inner" || f["outer"] != "outer" {
+		t.Error("fields from every level should be on the context", f)
+	}
+	if f[ctxerr.FieldKeyCode] != "INNER" {
+		t.Error("code did not match", f[ctxerr.FieldKeyCode])
+	}
 
-	ctxerr.AddHandleHook(func(_ error) {
-		handleHookRan = true
-	})
+	if f := ctxerr.Fields(ctxerr.ContextFromError(nil)); len(f) != 0 {
+		t.Error("nil error should have no fields", f)
+	}
+}
 
-	if createHookRan {
-		t.Error("create hook should not have run yet")
+func TestDeleteFields(t *testing.T) {
+	ctx := ctxerr.SetFields(context.Background(), map[string]any{"a": "a", "b": "b", "c": "c"})
+
+	if nctx := ctxerr.DeleteField(ctx, "missing"); nctx != ctx {
+		t.Error("deleting a missing field should return the same context")
 	}
-	err := ctxerr.New(context.Background(), "", "")
-	if !createHookRan {
-		t.Error("did not run create hook")
+	if nctx := ctxerr.DeleteFields(context.Background(), "a"); nctx != context.Background() {
+		t.Error("deleting without fields should return the same context")
 	}
 
-	if handleHookRan {
-		t.Error("handle hook should not have run yet")
+	actx := ctxerr.DeleteField(ctx, "a")
+	if f := ctxerr.Fields(actx); len(f) != 2 || f["a"] != nil {
+		t.Error("field should be deleted", f)
 	}
-	ctxerr.Handle(err)
-	if !handleHookRan {
-		t.Error("did not run handle hook")
+
+	bctx := ctxerr.DeleteFields(actx, "b", "c", "missing")
+	if f := ctxerr.Fields(bctx); len(f) != 0 {
+		t.Error("fields should be deleted", f)
 	}
 
+	if f := ctxerr.Fields(ctx); len(f) != 3 {
+		t.Error("original context should be unchanged", f)
+	}
 }
 
-func TestShortcutFunctions(t *testing.T) {
-	ctx := context.Background()
+func TestDetachAndCopyFields(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	parent = ctxerr.SetFields(parent, map[string]any{"a": "a", "b": "b"})
+
+	detached := ctxerr.DetachFields(parent)
+	cancel()
+	if parent.Err() == nil {
+		t.Error("parent should be canceled")
+	}
+	if detached.Err() != nil {
+		t.Error("detached context should not be canceled", detached.Err())
+	}
+	if f := ctxerr.Fields(detached); len(f) != 2 {
+		t.Error("detached fields did not match", f)
+	}
+
+	dst, dstCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer dstCancel()
+	dst = ctxerr.SetFields(dst, map[string]any{"b": "dst", "c": "c"})
+
+	copied := ctxerr.CopyFields(dst, parent)
+	if _, ok := copied.Deadline(); !ok {
+		t.Error("copied context should keep the deadline")
+	}
+	expected := map[string]any{"a": "a", "b": "b", "c": "c"}
+	if f := ctxerr.Fields(copied); !reflect.DeepEqual(f, expected) {
+		t.Errorf("copied fields didn't match \n%#v\n%#v", f, expected)
+	}
+	if f := ctxerr.Fields(dst); len(f) != 2 || f["b"] != "dst" {
+		t.Error("dst fields should not change", f)
+	}
+}
+
+func TestAllFields(t *testing.T) {
+	if f := ctxerr.AllFields(nil); f == nil {
+		t.Error("fields shouldn't have been nil")
+	}
+	if f := ctxerr.AllFields(errors.New("a")); len(f) != 0 {
+		t.Error("fields without a context should have no values")
+	}
+
+	ctx := context.Background()
+	ctx = ctxerr.SetField(ctx, "a", "a")
+	err := ctxerr.New(ctx, "a", "a")
+
+	ctx = ctxerr.SetField(ctx, "b", "b")
+	err = ctxerr.QuickWrap(ctx, err)
+
+	fields := ctxerr.AllFields(err)
+	b, ok := fields["b"]
+	if !ok {
+		t.Fatal("b was not found in fields")
+	}
+	if b != "b" {
+		t.Error("b value did not match", b)
+	}
+}
+
+func TestDeduplicateSliceFields(t *testing.T) {
+	ctx := context.Background()
+	err := func() error {
+		return ctxerr.New(ctx, "code", "msg")
+	}()
+	err = ctxerr.QuickWrap(ctx, err)
+	err = ctxerr.QuickWrap(ctx, err)
+	err = ctxerr.QuickWrap(ctx, err)
+
+	in := ctxerr.NewInstance()
+	if locs := in.AllFields(err)[ctxerr.FieldKeyLocation].([]any); len(locs) != 4 {
+		t.Error("expected every location without deduplication", locs)
+	}
+
+	in.DeduplicateSliceFields = []string{ctxerr.FieldKeyLocation}
+	locs := in.AllFields(err)[ctxerr.FieldKeyLocation].([]any)
+	if len(locs) != 2 {
+		t.Fatal("expected consecutive locations to be collapsed", locs)
+	}
+	if locs[0] != "ctxerr_test.TestDeduplicateSliceFields" {
+		t.Error("first location did not match", locs[0])
+	}
+	if !strings.HasPrefix(fmt.Sprint(locs[1]), "ctxerr_test.TestDeduplicateSliceFields.") {
+		t.Error("second location did not match", locs[1])
+	}
+
+	// Only adjacent values are collapsed
+	err = ctxerr.QuickWrap(ctx, func() error { return ctxerr.QuickWrap(ctx, err) }())
+	if locs := in.AllFields(err)[ctxerr.FieldKeyLocation].([]any); len(locs) != 4 {
+		t.Error("expected non-adjacent duplicates to remain", locs)
+	}
+}
+
+func TestAllFieldsConcurrent(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	err := ctxerr.New(ctx, "code", "msg")
+
+	// Simulate code that modifies the map on the context after the error is created
+	shared := ctxerr.Fields(ctx)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if f := ctxerr.AllFields(err); f["a"] != "a" {
+					t.Error("field did not match", f["a"])
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 100 {
+			ctx = ctxerr.SetField(ctx, "b", i)
+			shared["a"] = i
+		}
+	}()
+	wg.Wait()
+
+	if ctxerr.HasField(err, "b") {
+		t.Error("fields set after creation should not be on the error")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	err := NewFieldError("msg", map[string]any{"password": "hunter2", "user": "me"})
+	err = ctxerr.Wrap(ctxerr.SetField(context.Background(), "token", "abc"), err, "code")
+
+	redactor := func(key string, value any) any {
+		if key == "password" || key == "token" {
+			return "[redacted]"
+		}
+		return value
+	}
+
+	f := ctxerr.Redact(err, redactor)
+	if f["password"] != "[redacted]" || f["token"] != "[redacted]" {
+		t.Error("secrets were not redacted", f)
+	}
+	if f["user"] != "me" || f[ctxerr.FieldKeyCode] != "code" {
+		t.Error("other fields should not change", f)
+	}
+
+	if f := ctxerr.Redact(err, nil); f["password"] != "hunter2" {
+		t.Error("nil redactor should not change fields", f)
+	}
+}
+
+func TestFieldsWithDepth(t *testing.T) {
+	ctx := context.Background()
+	err := ctxerr.New(ctxerr.SetField(ctx, "c", "c"), "INNER", "inner")
+	err = ctxerr.QuickWrap(ctxerr.SetField(ctx, "b", "b"), err)
+	err = ctxerr.Wrap(ctxerr.SetField(ctx, "a", "a"), err, "OUTER", "outer")
+
+	f := ctxerr.FieldsWithDepth(err)
+	expected := map[string]ctxerr.FieldWithDepth{
+		"a":                     {Value: "a", Depth: 0},
+		"b":                     {Value: "b", Depth: 1},
+		"c":                     {Value: "c", Depth: 2},
+		ctxerr.FieldKeyCode:     {Value: "INNER", Depth: 2},
+		ctxerr.FieldKeyLocation: {Value: []any{"ctxerr_test.TestFieldsWithDepth", "ctxerr_test.TestFieldsWithDepth", "ctxerr_test.TestFieldsWithDepth"}, Depth: 0},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expected)
+	}
+
+	if f := ctxerr.FieldsWithDepth(errors.New("e")); len(f) != 0 {
+		t.Error("expected no fields", f)
+	}
+
+	in := ctxerr.NewInstance()
+	in.CreateHooks = nil
+	a := in.New(in.SetField(ctx, "a", "a"), "", "a")
+	b := in.Wrap(ctx, in.New(in.SetField(ctx, "b", "b"), "", "b"), "", "wrap")
+	joined := in.Wrap(in.SetField(ctx, "a", "outer"), errors.Join(a, b), "", "outer")
+	f = in.FieldsWithDepth(joined)
+	expected = map[string]ctxerr.FieldWithDepth{
+		"a": {Value: "a", Depth: 1},
+		"b": {Value: "b", Depth: 2},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("joined fields didn't match \n%#v\n%#v", f, expected)
+	}
+
+	in.JoinedFields = ctxerr.JoinedFieldsFirstWins
+	c := in.New(in.SetField(ctx, "a", "c"), "", "c")
+	f = in.FieldsWithDepth(errors.Join(a, in.Wrap(ctx, c, "", "wrap")))
+	if v := f["a"]; v.Value != "a" || v.Depth != 0 {
+		t.Error("depth should be of the value that won", v)
+	}
+}
+
+func TestMaxFieldValueBytes(t *testing.T) {
+	ctx := ctxerr.SetFields(context.Background(), map[string]any{
+		"normal": "normal",
+		"big":    strings.Repeat("a", 20),
+		"bytes":  []byte(strings.Repeat("b", 20)),
+		"struct": map[string]string{"body": strings.Repeat("c", 20)},
+		"small":  map[string]string{"d": "d"},
+	})
+	err := ctxerr.New(ctx, "code", "msg")
+
+	in := ctxerr.NewInstance()
+	if f := in.AllFields(err); f["big"] != strings.Repeat("a", 20) {
+		t.Error("values should not be truncated by default", f["big"])
+	}
+
+	in.MaxFieldValueBytes = 10
+	f := in.AllFields(err)
+	expected := map[string]any{
+		"normal":                "normal",
+		"big":                   "aaaaaaaaaa<truncated 10 bytes>",
+		"bytes":                 []byte("bbbbbbbbbb"),
+		"struct":                "<truncated 31 bytes>",
+		"small":                 map[string]string{"d": "d"},
+		ctxerr.FieldKeyCode:     "code",
+		ctxerr.FieldKeyLocation: []any{"ctxerr_tes<truncated 24 bytes>"},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expected)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	actx := ctxerr.SetField(context.Background(), "a", "a")
+	actx = ctxerr.SetCategory(actx, "cat_a")
+	a := ctxerr.New(actx, "CODE_A", "msg_a")
+
+	bctx := ctxerr.SetField(context.Background(), "b", "b")
+	bctx = ctxerr.SetCategory(bctx, "cat_b")
+	b := ctxerr.New(bctx, "CODE_B", "msg_b")
+
+	ctx := ctxerr.SetField(context.Background(), "shared", "shared")
+
+	if err := ctxerr.Join(ctx, "CODE_J", nil, nil); err != nil {
+		t.Error("joining only nils should be nil", err)
+	}
+
+	t.Run("single", func(t *testing.T) {
+		err := ctxerr.Join(ctx, "CODE_J", nil, a, nil)
+		if err.Error() != "msg_a" {
+			t.Error("message did not match", err.Error())
+		}
+		f := ctxerr.AllFields(err)
+		if f[ctxerr.FieldKeyCode] != "CODE_J" {
+			t.Error("code should be from the join", f[ctxerr.FieldKeyCode])
+		}
+		if f["shared"] != "shared" || f["a"] != "a" {
+			t.Error("fields did not match", f)
+		}
+		if !errors.Is(err, a) {
+			t.Error("should wrap the remaining error")
+		}
+	})
+
+	t.Run("multiple", func(t *testing.T) {
+		err := ctxerr.Join(ctx, "CODE_J", a, nil, b)
+		if err.Error() != "msg_a\nmsg_b" {
+			t.Error("message did not match", err.Error())
+		}
+		f := ctxerr.AllFields(err)
+		if f[ctxerr.FieldKeyCode] != "CODE_J" {
+			t.Error("code should be from the join", f[ctxerr.FieldKeyCode])
+		}
+		if f["shared"] != "shared" || f["a"] != "a" || f["b"] != "b" {
+			t.Error("fields did not match", f)
+		}
+		if locs := f[ctxerr.FieldKeyLocation].([]any); len(locs) != 3 {
+			t.Error("locations should be gathered from every error", locs)
+		}
+		if c := ctxerr.Categories(err); !reflect.DeepEqual(c, []any{"cat_a", "cat_b"}) {
+			t.Error("categories did not match", c)
+		}
+		if !ctxerr.HasCode(err, "CODE_A") || !ctxerr.HasCode(err, "CODE_B") {
+			t.Error("codes of the branches should be found")
+		}
+	})
+
+	t.Run("branch fields win", func(t *testing.T) {
+		sctx := ctxerr.SetHTTPStatusCode(context.Background(), 500)
+		branch := ctxerr.New(ctxerr.SetHTTPStatusCode(context.Background(), 404), "CODE_A")
+		f := ctxerr.AllFields(ctxerr.Join(sctx, "CODE_J", branch))
+		if f[ctxerr.FieldKeyStatusCode] != 404 {
+			t.Error("status code should be from the branch like Wrap", f[ctxerr.FieldKeyStatusCode])
+		}
+		if f[ctxerr.FieldKeyCode] != "CODE_J" {
+			t.Error("code should be from the join", f[ctxerr.FieldKeyCode])
+		}
+	})
+}
+
+func TestJoinedErrorsIs(t *testing.T) {
+	sentinel := errors.New("sentinel")
+	ctx := context.Background()
+	a := ctxerr.New(ctx, "CODE_A", "msg_a")
+	b := ctxerr.Wrap(ctx, sentinel, "CODE_B", "msg_b")
+
+	// Wrap only has Unwrap() error but errors.Is still descends into the branches of the join it wraps
+	err := ctxerr.Wrap(ctx, errors.Join(a, b), "CODE_C", "msg_c")
+	if !errors.Is(err, sentinel) {
+		t.Error("sentinel in a joined branch should be found")
+	}
+	if errors.Is(err, errors.New("sentinel")) {
+		t.Error("other errors should not be found")
+	}
+
+	err = ctxerr.Join(ctx, "CODE_J", a, fmt.Errorf("b: %w", sentinel))
+	if !errors.Is(err, sentinel) {
+		t.Error("sentinel in a Join branch should be found")
+	}
+}
+
+func TestJoinedFieldsMode(t *testing.T) {
+	actx := ctxerr.SetField(context.Background(), "a", "a")
+	actx = ctxerr.SetCategory(actx, "cat_a")
+	a := ctxerr.New(actx, "CODE_A", "msg_a")
+
+	bctx := ctxerr.SetField(context.Background(), "b", "b")
+	bctx = ctxerr.SetCategory(bctx, "cat_b")
+	b := ctxerr.New(bctx, "CODE_B", "msg_b")
+
+	cctx := ctxerr.SetField(context.Background(), "c", "c")
+	cctx = ctxerr.SetCategory(cctx, "cat_b")
+	c := ctxerr.New(cctx, "CODE_C", "msg_c")
+
+	err := ctxerr.Wrap(context.Background(), errors.Join(a, b, c), "CODE_D", "msg_d")
+
+	tests := []struct {
+		name             string
+		mode             ctxerr.JoinedFieldsMode
+		expectedCode     any
+		expectedCategory any
+	}{
+		{name: "last wins", mode: ctxerr.JoinedFieldsLastWins, expectedCode: "CODE_C", expectedCategory: "cat_b"},
+		{name: "first wins", mode: ctxerr.JoinedFieldsFirstWins, expectedCode: "CODE_A", expectedCategory: "cat_a"},
+		{name: "collect", mode: ctxerr.JoinedFieldsCollect, expectedCode: []any{"CODE_A", "CODE_B", "CODE_C"}, expectedCategory: []any{"cat_a", "cat_b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := ctxerr.NewInstance()
+			in.JoinedFields = tt.mode
+			f := in.AllFields(err)
+
+			if v := f[ctxerr.FieldKeyCode]; !reflect.DeepEqual(v, tt.expectedCode) {
+				t.Error("code did not match", v, tt.expectedCode)
+			}
+			if v := f[ctxerr.FieldKeyCategory]; !reflect.DeepEqual(v, tt.expectedCategory) {
+				t.Error("category did not match", v, tt.expectedCategory)
+			}
+			if f["a"] != "a" || f["b"] != "b" || f["c"] != "c" {
+				t.Error("fields without collisions should all be there", f)
+			}
+			if locs := f[ctxerr.FieldKeyLocation].([]any); len(locs) != 4 {
+				t.Error("slice fields should still be gathered", locs)
+			}
+		})
+	}
+}
+
+func TestFieldsSnapshot(t *testing.T) {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc")
+	ctx = ctxerr.SetField(ctx, "a", "a")
+	err := ctxerr.Wrap(ctx, errors.New("inner"), "code", "msg")
+
+	// Mutating the map shared by the context should not change the error
+	shared := ctxerr.Fields(ctx)
+	shared["a"] = "changed"
+	shared["b"] = "b"
+
+	ce, ok := ctxerr.As(err)
+	if !ok {
+		t.Fatal("should be a CtxErr")
+	}
+	if f := ce.Fields(); f["a"] != "a" || f["b"] != nil {
+		t.Error("fields should be a snapshot from creation", f)
+	}
+	if f := ctxerr.AllFields(err); f["a"] != "a" || f["b"] != nil {
+		t.Error("all fields should be a snapshot from creation", f)
+	}
+	if ce.Context().Value(requestIDKey{}) != "abc" {
+		t.Error("context should come from the original")
+	}
+}
+
+func TestAs(t *testing.T) {
+	tests := []struct {
+		in  error
+		out bool
+	}{
+		{in: nil, out: false},
+		{in: errors.New("errors"), out: false},
+		{in: ctxerr.New(context.Background(), "-", "ctxerr"), out: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintln(tt.in), func(t *testing.T) {
+			if _, b := ctxerr.As(tt.in); b != tt.out {
+				t.Error("bool did not match")
+			}
+		})
+	}
+
+}
+
+func TestFallbackHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		HandleHooks []func(error)
+		expectedLog bool
+	}{
+		{name: "fallback",
+			HandleHooks: nil,
+			expectedLog: true,
+		},
+		{name: "normal",
+			HandleHooks: []func(error){func(error) {}},
+			expectedLog: false,
+		},
+	}
+
+	err := ctxerr.New(context.Background(), "", "msg")
+	sb := &strings.Builder{}
+	log.SetOutput(sb)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb.Reset()
+			in := ctxerr.NewInstance()
+			in.HandleHooks = tt.HandleHooks
+			in.Handle(err)
+
+			msg := sb.String()
+			if (len(sb.String()) == 0) == tt.expectedLog {
+				t.Error("expectedLog did not match:", msg)
+			}
+		})
+	}
+}
+
+func TestCustomizations(t *testing.T) {
+	var createHookRan bool
+	var handleHookRan bool
+
+	ctxerr.AddCreateHook(func(ctx context.Context, _ string, _ error) context.Context {
+		createHookRan = true
+		return ctx
+	})
+
+	ctxerr.AddHandleHook(func(_ error) {
+		handleHookRan = true
+	})
+
+	if createHookRan {
+		t.Error("create hook should not have run yet")
+	}
+	err := ctxerr.New(context.Background(), "", "")
+	if !createHookRan {
+		t.Error("did not run create hook")
+	}
+
+	if handleHookRan {
+		t.Error("handle hook should not have run yet")
+	}
+	ctxerr.Handle(err)
+	if !handleHookRan {
+		t.Error("did not run handle hook")
+	}
+
+}
+
+func TestShortcutFunctions(t *testing.T) {
+	ctx := context.Background()
 
 	code := http.StatusOK
 	ctx = ctxerr.SetHTTPStatusCode(ctx, code)
@@ -703,454 +1547,2531 @@ func TestShortcutFunctions(t *testing.T) {
 		t.Error("SetHTTPStatusCode did work as expected: ", v)
 	}
 
-	action := "action"
-	ctx = ctxerr.SetAction(ctx, action)
-	if v := ctxerr.Fields(ctx)[ctxerr.FieldKeyAction]; v != action {
-		t.Error("SetAction did work as expected: ", v)
+	action := "action"
+	ctx = ctxerr.SetAction(ctx, action)
+	if v := ctxerr.Fields(ctx)[ctxerr.FieldKeyAction]; v != action {
+		t.Error("SetAction did work as expected: ", v)
+	}
+
+	category := "category"
+	ctx = ctxerr.SetCategory(ctx, category)
+	if v := ctxerr.Fields(ctx)[ctxerr.FieldKeyCategory]; v != category {
+		t.Error("SetCategory did work as expected: ", v)
+	}
+}
+
+func TestWrappingWithNoUnderlyingCode(t *testing.T) {
+	expectedCode := "expected"
+	ctx := context.Background()
+	err := ctxerr.New(ctx, "", "0")
+	err = ctxerr.Wrap(ctx, err, expectedCode, "1")
+
+	code := ctxerr.AllFields(err)[ctxerr.FieldKeyCode]
+	if code != expectedCode {
+		t.Error("code did not match", code)
+	}
+}
+
+func TestDefaultCode(t *testing.T) {
+	ctx := context.Background()
+	in := ctxerr.NewInstance()
+	in.DefaultCode = "DEFAULT"
+
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode any
+	}{
+		{name: "explicit code", err: in.New(ctx, "EXPLICIT", "msg"), expectedCode: "EXPLICIT"},
+		{name: "empty code", err: in.New(ctx, "", "msg"), expectedCode: "DEFAULT"},
+		{name: "empty code newf", err: in.Newf(ctx, "", "%s", "msg"), expectedCode: "DEFAULT"},
+		{name: "empty code wrap", err: in.Wrap(ctx, errors.New("e"), "", "msg"), expectedCode: "DEFAULT"},
+		{name: "quick wrap", err: in.QuickWrap(ctx, errors.New("e")), expectedCode: "DEFAULT"},
+		{name: "wrapped code", err: in.Wrap(ctx, ctxerr.New(ctx, "INNER", "inner"), "", "msg"), expectedCode: "INNER"},
+		{name: "wrapped code wrapf", err: in.Wrapf(ctx, ctxerr.New(ctx, "INNER", "inner"), "", "msg"), expectedCode: "INNER"},
+		{name: "context code", err: in.New(ctxerr.SetField(ctx, ctxerr.FieldKeyCode, "CONTEXT"), "", "msg"), expectedCode: "CONTEXT"},
+		{name: "no default", err: ctxerr.New(ctx, "", "msg"), expectedCode: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := ctxerr.AllFields(tt.err)[ctxerr.FieldKeyCode]; code != tt.expectedCode {
+				t.Error("code did not match", code, tt.expectedCode)
+			}
+			if ce, _ := ctxerr.As(tt.err); tt.expectedCode == "INNER" && ce.Fields()[ctxerr.FieldKeyCode] != nil {
+				t.Error("default should not be added to the wrapper", ce.Fields())
+			}
+		})
+	}
+}
+
+func TestHTTPFuncs(t *testing.T) {
+	tests := []struct {
+		name               string
+		err                error
+		expectedCode       any
+		expectedAction     any
+		expectedStatusCode any
+		expectedMessage    string
+	}{
+		{
+			name:               "NewHTTP",
+			err:                ctxerr.NewHTTP(context.Background(), "c", "a", http.StatusBadRequest, "m", 1, "v"),
+			expectedCode:       "c",
+			expectedAction:     "a",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedMessage:    "m1v",
+		},
+		{
+			name:               "NewHTTPf",
+			err:                ctxerr.NewHTTPf(context.Background(), "c", "a", http.StatusConflict, "m %02d%s", 1, "v"),
+			expectedCode:       "c",
+			expectedAction:     "a",
+			expectedStatusCode: http.StatusConflict,
+			expectedMessage:    "m 01v",
+		},
+		{
+			name:               "WrapHTTP",
+			err:                ctxerr.WrapHTTP(context.Background(), fmt.Errorf("e"), "c", "a", 0, "m", 1, "v"),
+			expectedCode:       "c",
+			expectedAction:     "a",
+			expectedStatusCode: nil,
+			expectedMessage:    "m1v : e",
+		},
+		{
+			name:               "WrapHTTPf",
+			err:                ctxerr.WrapHTTPf(context.Background(), fmt.Errorf("e"), "c", "a", http.StatusBadRequest, "m %02d%s", 1, "v"),
+			expectedCode:       "c",
+			expectedAction:     "a",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedMessage:    "m 01v : e",
+		},
+		{
+			name:               "WrapHTTP nil error",
+			err:                ctxerr.WrapHTTP(context.Background(), nil, "c", "a", 0, "m", 1, "v"),
+			expectedCode:       nil,
+			expectedAction:     nil,
+			expectedStatusCode: nil,
+			expectedMessage:    "ignored",
+		},
+		{
+			name:               "WrapHTTPf nil error",
+			err:                ctxerr.WrapHTTPf(context.Background(), nil, "c", "a", http.StatusBadRequest, "m %02d%s", 1, "v"),
+			expectedCode:       nil,
+			expectedAction:     nil,
+			expectedStatusCode: nil,
+			expectedMessage:    "ignored",
+		},
+		{
+			name: "Status-code-already-on-context",
+			err: func() error {
+				ctx := ctxerr.SetHTTPStatusCode(context.Background(), http.StatusInternalServerError)
+				return ctxerr.NewHTTP(ctx, "c", "a", http.StatusBadRequest, "m")
+			}(),
+			expectedCode:       "c",
+			expectedAction:     "a",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedMessage:    "m",
+		},
+		{
+			name: "Wrapped error already has status code and action",
+			err: func() error {
+				err := ctxerr.NewHTTP(context.Background(), "ci", "ai", http.StatusBadRequest, "mi")
+				return ctxerr.WrapHTTP(context.Background(), err, "co", "ao", http.StatusConflict, "mo")
+			}(),
+			expectedCode:       "ci",
+			expectedAction:     "ai",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedMessage:    "mo : mi",
+		},
+		{
+			name: "Wrapped error already has status code and action same context",
+			err: func() error {
+				ctx := context.Background()
+				err := ctxerr.NewHTTP(ctx, "ci", "ai", http.StatusBadRequest, "mi")
+				return ctxerr.WrapHTTP(ctx, err, "co", "ao", http.StatusConflict, "mo")
+			}(),
+			expectedCode:       "ci",
+			expectedAction:     "ai",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedMessage:    "mo : mi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ctxerr.AllFields(tt.err)
+
+			if v := f[ctxerr.FieldKeyCode]; v != tt.expectedCode {
+				t.Errorf("code did not match: %v", v)
+			}
+			if v := f[ctxerr.FieldKeyAction]; v != tt.expectedAction {
+				t.Errorf("action did not match: %v - %v", v, tt.expectedAction)
+			}
+			if v := f[ctxerr.FieldKeyStatusCode]; v != tt.expectedStatusCode {
+				t.Errorf("status code did not match: %v - %v", v, tt.expectedStatusCode)
+			}
+			if tt.err != nil {
+				if v := tt.err.Error(); v != tt.expectedMessage {
+					t.Errorf("message did not match: %v - %v", v, tt.expectedMessage)
+				}
+			}
+		})
+	}
+}
+
+// testStatus is a typed status code with a String method so it cannot be parsed from its string
+type testStatus int
+
+func (s testStatus) String() string { return http.StatusText(int(s)) }
+
+func TestInspect(t *testing.T) {
+	ctx := ctxerr.SetCategory(context.Background(), "category")
+	httpErr := ctxerr.NewHTTP(ctx, "code", "action", http.StatusNotFound, "msg")
+	wrapped := ctxerr.Wrap(context.Background(), errors.New("e"), "wrapped", "msg")
+	typedStatus := ctxerr.New(ctxerr.SetField(context.Background(), ctxerr.FieldKeyStatusCode, testStatus(http.StatusBadRequest)), "typed")
+
+	tests := []struct {
+		name     string
+		err      error
+		expected ctxerr.Info
+		ok       bool
+	}{
+		{
+			name: "http",
+			err:  httpErr,
+			expected: ctxerr.Info{
+				Code:       "code",
+				Category:   "category",
+				Action:     "action",
+				StatusCode: http.StatusNotFound,
+				Fields:     ctxerr.AllFields(httpErr),
+			},
+			ok: true,
+		},
+		{
+			name:     "wrapped",
+			err:      wrapped,
+			expected: ctxerr.Info{Code: "wrapped", Fields: ctxerr.AllFields(wrapped)},
+			ok:       true,
+		},
+		{
+			name:     "typed status",
+			err:      typedStatus,
+			expected: ctxerr.Info{Code: "typed", StatusCode: http.StatusBadRequest, Fields: ctxerr.AllFields(typedStatus)},
+			ok:       true,
+		},
+		{
+			name:     "go error",
+			err:      errors.New("e"),
+			expected: ctxerr.Info{Fields: map[string]any{}},
+			ok:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, ok := ctxerr.Inspect(tt.err)
+			if ok != tt.ok {
+				t.Error("ok did not match", ok)
+			}
+			if !reflect.DeepEqual(info, tt.expected) {
+				t.Errorf("info didn't match \n%#v\n%#v", info, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHasField(t *testing.T) {
+	ctx := context.Background()
+	err := ctxerr.New(ctx, "c")
+
+	if !ctxerr.HasField(err, ctxerr.FieldKeyCode) {
+		t.Error("expected code field", ctxerr.FieldKeyCode, ctxerr.AllFields(err))
+	}
+
+	key := "key"
+	if ctxerr.HasField(err, key) {
+		t.Error("expected no field", key, ctxerr.AllFields(err))
+	}
+
+	ctx = ctxerr.SetField(ctx, key, "")
+	err = ctxerr.Wrap(context.Background(), ctxerr.Wrap(ctx, fmt.Errorf(""), "c"), "c")
+
+	if !ctxerr.HasField(err, key) {
+		t.Error("expected field", key, ctxerr.AllFields(err))
+	}
+
+	err = fmt.Errorf("abc")
+	if ctxerr.HasField(err, ctxerr.FieldKeyCode) {
+		t.Error("expected no code field", ctxerr.FieldKeyCode, ctxerr.AllFields(err))
+	}
+}
+
+func TestImpossibilties(t *testing.T) {
+	// Main field key is not the a map
+	ctx := context.Background()
+	ctx = context.WithValue(ctx, ctxerr.FieldsKey, "s")
+	f := ctxerr.Fields(ctx)
+	if f != nil {
+		t.Error("expected fields to be nil")
+	}
+}
+
+func TestImpl(t *testing.T) {
+	var e1 ctxerr.CtxErr = ctxerr.New(context.Background(), "c1").(ctxerr.CtxErr)
+	var e2 ctxerr.CtxErr = ctxerr.New(context.Background(), "c2").(ctxerr.CtxErr)
+	if !e1.Is(e2) {
+		t.Error("expected is to work")
+	}
+
+	ctx := e1.Context()
+	if code := ctxerr.Fields(ctx)[ctxerr.FieldKeyCode]; code == "" {
+		t.Error("expected a code")
+	}
+	e1.WithContext(context.Background())
+	if code := ctxerr.Fields(ctx)[ctxerr.FieldKeyCode]; code == "" {
+		t.Error("expected no code got", code)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "foo", "bar")
+	err := ctxerr.New(ctx, "code", "inner")
+	err = ctxerr.Wrap(context.Background(), err, "", "outer")
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{format: "%v", expected: "outer : inner"},
+		{format: "%s", expected: "outer : inner"},
+		{format: "%q", expected: `"outer : inner"`},
+		{format: "%14.5s", expected: "         outer"},
+		{format: "%+v", expected: "outer : inner" +
+			"\n\terror_code: code" +
+			"\n\terror_location: ctxerr_test.TestFormat, ctxerr_test.TestFormat" +
+			"\n\tfoo: bar",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if s := fmt.Sprintf(tt.format, err); s != tt.expected {
+				t.Errorf("format did not match\n%s\n%s", s, tt.expected)
+			}
+		})
+	}
+
+	in := ctxerr.NewInstance()
+	in.AddFieldsFunc(func(error) map[string]any { return map[string]any{"instance": "in"} })
+	if s := fmt.Sprintf("%+v", in.New(ctx, "code", "msg")); !strings.Contains(s, "\n\tinstance: in") {
+		t.Error("fields of the instance that created the error should be used", s)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "foo", "bar")
+	ctx = ctxerr.SetField(ctx, "fn", func() {})
+	err := ctxerr.New(ctx, "code", "inner")
+	err = ctxerr.Wrap(context.Background(), err, "", "outer")
+
+	b, merr := json.Marshal(err)
+	if merr != nil {
+		t.Fatal("could not marshal", merr)
+	}
+
+	var v struct {
+		Message string         `json:"message"`
+		Fields  map[string]any `json:"fields"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal("could not unmarshal", err, string(b))
+	}
+
+	if v.Message != "outer : inner" {
+		t.Error("message did not match", v.Message)
+	}
+	if v.Fields[ctxerr.FieldKeyCode] != "code" {
+		t.Error("code did not match", v.Fields[ctxerr.FieldKeyCode])
+	}
+	if v.Fields["foo"] != "bar" {
+		t.Error("field did not match", v.Fields["foo"])
+	}
+	if s, ok := v.Fields["fn"].(string); !ok || s == "" {
+		t.Error("non JSON field should have been stringified", v.Fields["fn"])
+	}
+	if locs, ok := v.Fields[ctxerr.FieldKeyLocation].([]any); !ok || len(locs) != 2 {
+		t.Error("locations did not match", v.Fields[ctxerr.FieldKeyLocation])
+	}
+
+	in := ctxerr.NewInstance()
+	in.AddFieldsFunc(func(error) map[string]any { return map[string]any{"instance": "in"} })
+	if b, _ := json.Marshal(in.New(ctx, "code", "msg")); !strings.Contains(string(b), `"instance":"in"`) {
+		t.Error("fields of the instance that created the error should be used", string(b))
+	}
+}
+
+func TestGetCodeAndHasCode(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		err          error
+		expectedCode string
+		expectedOK   bool
+		hasCodes     []string
+	}{
+		{name: "nil", err: nil},
+		{name: "go error", err: errors.New("e")},
+		{
+			name:         "code",
+			err:          ctxerr.New(ctx, "CODE", "msg"),
+			expectedCode: "CODE",
+			expectedOK:   true,
+			hasCodes:     []string{"CODE"},
+		},
+		{
+			name:         "inner code",
+			err:          ctxerr.QuickWrap(ctx, fmt.Errorf("fmt : %w", ctxerr.New(ctx, "INNER", "msg"))),
+			expectedCode: "INNER",
+			expectedOK:   true,
+			hasCodes:     []string{"INNER"},
+		},
+		{
+			name:         "outer code",
+			err:          ctxerr.Wrap(ctx, ctxerr.New(ctx, "INNER", "msg"), "OUTER"),
+			expectedCode: "OUTER",
+			expectedOK:   true,
+			hasCodes:     []string{"OUTER", "INNER"},
+		},
+		{
+			name:         "non string code",
+			err:          ctxerr.New(ctxerr.SetField(ctx, ctxerr.FieldKeyCode, 7), "", "msg"),
+			expectedCode: "7",
+			expectedOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, ok := ctxerr.GetCode(tt.err)
+			if code != tt.expectedCode || ok != tt.expectedOK {
+				t.Error("code did not match", code, ok)
+			}
+			for _, c := range tt.hasCodes {
+				if !ctxerr.HasCode(tt.err, c) {
+					t.Error("expected code", c)
+				}
+			}
+			if ctxerr.HasCode(tt.err, "MISSING") {
+				t.Error("expected not to have code")
+			}
+		})
+	}
+}
+
+func TestCodeError(t *testing.T) {
+	ctx := context.Background()
+	inner := ctxerr.New(ctx, "INNER", "inner")
+	err := ctxerr.Wrap(ctx, fmt.Errorf("fmt : %w", inner), "OUTER", "outer")
+
+	prefixed := ctxerr.NewInstance()
+	prefixed.SetFieldKeyPrefix("err.")
+
+	tests := []struct {
+		name   string
+		err    error
+		target error
+		match  bool
+	}{
+		{name: "outer code", err: err, target: ctxerr.CodeError("OUTER"), match: true},
+		{name: "inner code", err: err, target: ctxerr.CodeError("INNER"), match: true},
+		{name: "missing code", err: err, target: ctxerr.CodeError("MISSING"), match: false},
+		{name: "joined", err: errors.Join(errors.New("a"), inner), target: ctxerr.CodeError("INNER"), match: true},
+		{name: "go error", err: errors.New("INNER"), target: ctxerr.CodeError("INNER"), match: false},
+		{name: "ctxerr target", err: err, target: ctxerr.New(ctx, "OTHER"), match: true},
+		{name: "go target", err: err, target: errors.New("other"), match: false},
+		{name: "prefixed instance", err: prefixed.New(ctx, "PREFIXED"), target: ctxerr.CodeError("PREFIXED"), match: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m := errors.Is(tt.err, tt.target); m != tt.match {
+				t.Error("match was unexpected", m)
+			}
+		})
+	}
+}
+
+func TestFeildsWithNilCtx(t *testing.T) {
+	var ctx context.Context
+	f := ctxerr.Fields(ctx)
+	if f != nil {
+		t.Error("expected a nil map")
+	}
+}
+
+type redactable string
+
+func (r redactable) Redact() any {
+	return "redacted"
+}
+
+type IRedactable interface {
+	Redact() any
+}
+
+func RedactItem(ctx context.Context, a any) any {
+	if v, ok := a.(IRedactable); ok {
+		return v.Redact()
+	}
+	return a
+}
+
+func TestFieldHook(t *testing.T) {
+	in := ctxerr.Instance{}
+	in.AddFieldHook(RedactItem)
+
+	var key = "key"
+	tests := []struct {
+		name string
+		f    func(context.Context, any) context.Context
+	}{
+		{
+			name: "in.SetField",
+			f: func(ctx context.Context, v any) context.Context {
+				return in.SetField(ctx, key, v)
+			},
+		},
+		{
+			name: "in.SetFields",
+			f: func(ctx context.Context, v any) context.Context {
+				return in.SetFields(ctx, map[string]any{key: v})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := redactable("hello")
+
+			ctx := context.Background()
+			ctx = tt.f(ctx, r)
+
+			v, ok := ctxerr.Fields(ctx)[key]
+			if !ok {
+				t.Fatal("key not set")
+			}
+
+			if v != "redacted" {
+				t.Error("hook did not work", v)
+			}
+		})
+	}
+}
+
+func TestFieldHookWithKey(t *testing.T) {
+	in := ctxerr.Instance{}
+	in.AddFieldHook(RedactItem)
+	in.AddFieldHookWithKey(func(_ context.Context, key string, value any) any {
+		if strings.Contains(key, "secret") {
+			return "redacted"
+		}
+		return value
+	})
+
+	ctx := in.SetField(context.Background(), "my_secret", "s")
+	ctx = in.SetField(ctx, "plain", "p")
+	ctx = in.SetFields(ctx, map[string]any{"secret_key": "k", "other": "o", "value": redactable("v")})
+	ctx = in.NewFieldBuilder(ctx).Set("builder_secret", "b").Context()
+
+	expected := map[string]any{
+		"my_secret":      "redacted",
+		"plain":          "p",
+		"secret_key":     "redacted",
+		"other":          "o",
+		"value":          "redacted",
+		"builder_secret": "redacted",
+	}
+	if f := ctxerr.Fields(ctx); !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expected)
+	}
+}
+
+func TestGlobalFieldsHook(t *testing.T) {
+	ctxerr.AddFieldHook(func(_ context.Context, a any) any { return a })
+	ctxerr.AddFieldHookWithKey(func(_ context.Context, _ string, a any) any { return a })
+}
+
+type FieldError struct {
+	fields map[string]any
+	err    error
+}
+
+func (fe FieldError) Error() string {
+	return fe.err.Error()
+}
+
+func (fe FieldError) Fields() map[string]any {
+	return fe.fields
+}
+
+func (fe FieldError) Unwrap() error {
+	return errors.Unwrap(fe.err)
+}
+
+func NewFieldError(msg string, fields map[string]any) error {
+	return FieldError{
+		fields: fields,
+		err:    fmt.Errorf(msg),
+	}
+}
+
+func WrapFieldError(err error, msg string, fields map[string]any) error {
+	return FieldError{
+		fields: fields,
+		err:    fmt.Errorf("%s : %w", msg, err),
+	}
+}
+
+func TestAllFieldsWithMultipleTypesOfErrors(t *testing.T) {
+	var _ error = FieldError{}
+
+	err := NewFieldError("bottom", map[string]any{"a": "a"})
+	err = fmt.Errorf("fmt : %w", err)
+	ctx := ctxerr.SetField(context.Background(), "b", "b")
+	ctx = ctxerr.SetCategory(ctx, "category1")
+	err = ctxerr.Wrap(ctx, err, "CTXERR_CODE_1", "ctxerr1")
+	ctx = ctxerr.SetCategory(ctx, "category2")
+	err = ctxerr.Wrap(ctx, err, "CTXERR_CODE_2", "ctxerr2")
+	err = WrapFieldError(err, "wrapfe", map[string]any{"c": "c"})
+
+	expectedMessage := "wrapfe : ctxerr2 : ctxerr1 : fmt : bottom"
+	if msg := err.Error(); msg != expectedMessage {
+		t.Errorf("message didn't match \n'%s'\n'%s'", msg, expectedMessage)
+	}
+
+	f := ctxerr.AllFields(err)
+	expectedFields := map[string]any{
+		"a":              "a",
+		"b":              "b",
+		"c":              "c",
+		"error_code":     "CTXERR_CODE_1",
+		"error_category": "category1",
+		"error_location": []any{
+			"ctxerr_test.TestAllFieldsWithMultipleTypesOfErrors",
+			"ctxerr_test.TestAllFieldsWithMultipleTypesOfErrors",
+		},
+	}
+	if !reflect.DeepEqual(f, expectedFields) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expectedFields)
+	}
+
+	if !ctxerr.HasField(err, "a") {
+		t.Error("missing in HasField")
+	}
+
+	if !ctxerr.HasCategory(err, "category2") {
+		t.Error("missing category2")
+	}
+	if !ctxerr.HasCategory(err, "category1") {
+		t.Error("missing category1")
+	}
+}
+
+type OtherFieldFuncError struct {
+	fields map[string]any
+	err    error
+}
+
+func (offe OtherFieldFuncError) Error() string {
+	return offe.err.Error()
+}
+
+func (offe OtherFieldFuncError) FieldsMap() map[string]any {
+	return offe.fields
+}
+
+func (offe OtherFieldFuncError) Unwrap() error {
+	return errors.Unwrap(offe.err)
+}
+
+type IFieldsMap interface {
+	FieldsMap() map[string]any
+}
+
+func NewOtherFieldFuncError(msg string, fields map[string]any) error {
+	return OtherFieldFuncError{
+		fields: fields,
+		err:    fmt.Errorf(msg),
+	}
+}
+
+func TestAddFieldsFuncs(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.AddFieldsFunc(func(err error) map[string]any {
+		if v, ok := err.(IFieldsMap); ok {
+			return v.FieldsMap()
+		}
+		return nil
+	})
+
+	err := NewOtherFieldFuncError("msg", map[string]any{"a": "a"})
+	if in.AllFields(err)["a"] != "a" {
+		t.Error("field not set from interface")
+	}
+
+	err = ctxerr.New(ctxerr.SetField(context.Background(), "b", "b"), "CODE", "msg")
+	if in.AllFields(err)["b"] != "b" {
+		t.Error("field not set from default interface")
+	}
+
+	// Ensure empty funcs defaults
+	in.GetFieldsFuncs = nil
+	ctx := ctxerr.SetField(context.Background(), "c", "c")
+	ctx = ctxerr.SetCategory(ctx, "foo")
+	err = ctxerr.New(ctx, "CODE", "msg")
+	if in.AllFields(err)["c"] != "c" {
+		t.Error("field not set from default interface fallback")
+	}
+	if !in.HasCategory(err, "foo") {
+		t.Error("missing category")
+	}
+	if !in.HasField(err, "c") {
+		t.Error("missing hasField")
+	}
+}
+
+func TestGlobaTestAddFieldsFuncs(t *testing.T) {
+	ctxerr.AddFieldsFunc(func(_ error) map[string]any { return nil })
+}
+
+type requestIDKey struct{}
+
+type contextError struct {
+	ctx context.Context
+}
+
+func (ce contextError) Error() string            { return "context error" }
+func (ce contextError) Context() context.Context { return ce.ctx }
+
+func TestAddFieldsFuncCtx(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.AddFieldsFuncCtx(func(ctx context.Context, _ error) map[string]any {
+		if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+			return map[string]any{"request_id": id}
+		}
+		return nil
+	})
+
+	err := error(contextError{ctx: context.WithValue(context.Background(), requestIDKey{}, "abc")})
+	err = in.Wrap(context.Background(), err, "CODE", "wrapped")
+
+	if v := in.AllFields(err)["request_id"]; v != "abc" {
+		t.Error("field not set from the context", v)
+	}
+	if !in.HasField(err, "request_id") {
+		t.Error("missing hasField")
+	}
+	if in.AllFields(err)[ctxerr.FieldKeyCode] != "CODE" {
+		t.Error("fields from the other funcs should remain")
+	}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "def")
+	err = in.New(ctx, "CODE", "msg")
+	if v := in.AllFields(err)["request_id"]; v != "def" {
+		t.Error("field not set from the ctxerr context", v)
+	}
+	if v := in.AllFields(errors.New("plain"))["request_id"]; v != nil {
+		t.Error("errors without a context should use a background context", v)
+	}
+}
+
+func TestGlobalAddFieldsFuncCtx(t *testing.T) {
+	ctxerr.AddFieldsFuncCtx(func(context.Context, error) map[string]any { return nil })
+}
+
+func TestJoined(t *testing.T) {
+	actx := ctxerr.SetField(context.Background(), "a", "a")
+	actx = ctxerr.SetCategory(actx, "cat_a")
+	a := ctxerr.New(actx, "CODE_A", "msg_a")
+
+	bctx := ctxerr.SetField(context.Background(), "b", "b")
+	bctx = ctxerr.SetCategory(bctx, "cat_b")
+	b := ctxerr.New(bctx, "CODE_B", "msg_b")
+
+	cctx := ctxerr.SetField(context.Background(), "c", "c")
+	c := ctxerr.Wrap(cctx, errors.Join(a, b), "CODE_C", "msg_c")
+
+	if !ctxerr.HasCategory(c, "cat_a") {
+		t.Error("missing category cat_a")
+	}
+	if !ctxerr.HasCategory(c, "cat_b") {
+		t.Error("missing category cat_b")
+	}
+
+	if !ctxerr.HasField(c, "a") {
+		t.Error("missing field a")
+	}
+	if !ctxerr.HasField(c, "b") {
+		t.Error("missing field b")
+	}
+
+	f := ctxerr.AllFields(c)
+	expectedFields := map[string]any{
+		"a":              "a",
+		"b":              "b",
+		"c":              "c",
+		"error_code":     "CODE_B",
+		"error_category": "cat_b",
+		"error_location": []any{
+			"ctxerr_test.TestJoined",
+			"ctxerr_test.TestJoined",
+			"ctxerr_test.TestJoined",
+		},
+	}
+
+	if !reflect.DeepEqual(f, expectedFields) {
+		t.Errorf("fields didn't match \n%#v\n%#v", f, expectedFields)
+	}
+}
+
+func TestRateLimitedHandleHook(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	in := ctxerr.NewInstance()
+	in.Now = func() time.Time { return now }
+
+	var handled []error
+	hook := in.RateLimitedHandleHook(func(err error) { handled = append(handled, err) }, 2, time.Minute, nil)
+
+	ctx := context.Background()
+	a := in.New(ctx, "A", "a")
+	b := in.New(ctx, "B", "b")
+	for range 5 {
+		hook(a)
+	}
+	hook(b)
+	if len(handled) != 3 {
+		t.Fatal("repeats in the window should be suppressed", len(handled))
+	}
+	for _, err := range handled {
+		if in.HasField(err, ctxerr.FieldKeySuppressedCount) {
+			t.Error("nothing was suppressed before these errors", err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	hook(a)
+	if len(handled) != 4 {
+		t.Fatal("a new window should handle the error", len(handled))
+	}
+	last := handled[3]
+	if v := in.AllFields(last)[ctxerr.FieldKeySuppressedCount]; v != 3 {
+		t.Error("suppressed count did not match", v)
+	}
+	if last.Error() != "a" || !errors.Is(last, a) {
+		t.Error("handled error should wrap the original", last)
+	}
+	if code, _ := in.GetCode(last); code != "A" {
+		t.Error("code should be unchanged", code)
+	}
+
+	hook(a)
+	if v, ok := in.AllFields(handled[4])[ctxerr.FieldKeySuppressedCount]; ok {
+		t.Error("suppressed count should reset after it is reported", v)
+	}
+
+	hook(a)
+	hook(a)
+	now = now.Add(2 * time.Minute)
+	hook(b)
+	hook(a)
+	if v, ok := in.AllFields(handled[len(handled)-1])[ctxerr.FieldKeySuppressedCount]; ok {
+		t.Error("keys not seen for a window should be forgotten", v)
+	}
+
+	// The global is read when the hook runs
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	handled = nil
+	hook = ctxerr.RateLimitedHandleHook(func(err error) { handled = append(handled, err) }, 1, time.Minute, nil)
+	ctxerr.SetGlobal(in)
+	hook(a)
+	hook(a)
+	now = now.Add(time.Minute)
+	hook(a)
+	if len(handled) != 2 || in.AllFields(handled[1])[ctxerr.FieldKeySuppressedCount] != 1 {
+		t.Error("the instance set after creating the hook was not used", handled)
+	}
+}
+
+func wrapperNew(ctx context.Context, code string) error {
+	return ctxerr.New(ctx, code, "from a wrapper")
+}
+
+func TestAddCallerSkipPrefix(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	err := wrapperNew(context.Background(), "CODE")
+	if v := ctxerr.AllFields(err)[ctxerr.FieldKeyLocation]; !reflect.DeepEqual(v, []any{"ctxerr_test.wrapperNew"}) {
+		t.Error("location should be the wrapper before adding the prefix", v)
+	}
+
+	ctxerr.AddCallerSkipPrefix("ctxerr_test.wrapperNew")
+	err = wrapperNew(context.Background(), "CODE")
+	if v := ctxerr.AllFields(err)[ctxerr.FieldKeyLocation]; !reflect.DeepEqual(v, []any{"ctxerr_test.TestAddCallerSkipPrefix"}) {
+		t.Error("location should skip the wrapper", v)
+	}
+
+	in := ctxerr.NewInstance()
+	in.AddCallerSkipPrefix("ctxerr_test.TestAddCallerSkipPrefix")
+	if f := in.CallerFunc(0); strings.HasPrefix(f, "ctxerr_test.TestAddCallerSkipPrefix") {
+		t.Error("the instance should skip its prefixes", f)
+	}
+	if f := saved.CallerFunc(0); f != "ctxerr_test.TestAddCallerSkipPrefix" {
+		t.Error("other instances should not skip the prefix", f)
+	}
+}
+
+func TestSampledHandleHook(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	in := ctxerr.NewInstance()
+	in.Rand = r.Float64
+
+	err := in.New(context.Background(), "CODE", "msg")
+	for _, fraction := range []float64{0, 0.25, 1} {
+		var handled int
+		hook := in.SampledHandleHook(func(error) { handled++ }, fraction)
+		for range 10000 {
+			hook(err)
+		}
+		if rate := float64(handled) / 10000; math.Abs(rate-fraction) > 0.02 {
+			t.Error("rate did not match the fraction", rate, fraction)
+		}
+	}
+
+	// The global is read when the hook runs
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	var handled int
+	hook := ctxerr.SampledHandleHook(func(error) { handled++ }, 0.5)
+	in.Rand = func() float64 { return 0 }
+	ctxerr.SetGlobal(in)
+	hook(err)
+	in.Rand = func() float64 { return 0.9 }
+	ctxerr.SetGlobal(in)
+	hook(err)
+	if handled != 1 {
+		t.Error("the instance set after creating the hook was not used", handled)
+	}
+}
+
+func TestCodeSampledHandleHook(t *testing.T) {
+	ctx := context.Background()
+	for _, fraction := range []float64{0, 0.5, 1} {
+		handled := map[string]int{}
+		hook := ctxerr.CodeSampledHandleHook(func(err error) {
+			code, _ := ctxerr.GetCode(err)
+			handled[code]++
+		}, fraction)
+
+		for i := range 1000 {
+			code := fmt.Sprint("CODE_", i)
+			hook(ctxerr.New(ctx, code, "first"))
+			hook(ctxerr.New(ctx, code, "second"))
+		}
+		for code, n := range handled {
+			if n != 2 {
+				t.Error("a code should always or never be handled", code, n)
+			}
+		}
+		if rate := float64(len(handled)) / 1000; math.Abs(rate-fraction) > 0.05 {
+			t.Error("rate did not match the fraction", rate, fraction)
+		}
+	}
+}
+
+func TestNewHTTPStatus(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		code       string
+	}{
+		{statusCode: http.StatusNotFound, code: "not_found"},
+		{statusCode: http.StatusBadRequest, code: "bad_request"},
+		{statusCode: http.StatusNonAuthoritativeInfo, code: "non_authoritative_information"},
+		{statusCode: http.StatusTeapot, code: "im_a_teapot"},
+		{statusCode: 999, code: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.statusCode), func(t *testing.T) {
+			err := ctxerr.NewHTTPStatus(context.Background(), tt.statusCode, "msg")
+			f := ctxerr.AllFields(err)
+			if v, _ := f[ctxerr.FieldKeyCode].(string); v != tt.code {
+				t.Error("code did not match", v, tt.code)
+			}
+			if f[ctxerr.FieldKeyStatusCode] != tt.statusCode {
+				t.Error("status code did not match", f[ctxerr.FieldKeyStatusCode])
+			}
+			if _, ok := f[ctxerr.FieldKeyAction]; ok {
+				t.Error("action should not be set")
+			}
+		})
+	}
+
+	ctx := ctxerr.SetField(context.Background(), ctxerr.FieldKeyCode, "EXISTING")
+	err := ctxerr.NewHTTPStatus(ctx, http.StatusNotFound, "msg")
+	if code, _ := ctxerr.GetCode(err); code != "EXISTING" {
+		t.Error("code on the context should be kept", code)
+	}
+}
+
+func TestRegisteredCodes(t *testing.T) {
+	in := ctxerr.NewInstance()
+	var handled []error
+	in.AddHandleHook(func(err error) { handled = append(handled, err) })
+	in.AddCreateHook(in.ValidateRegisteredCodeHook)
+	in.RegisterCode("REGISTERED", "a registered code")
+
+	ctx := context.Background()
+	in.New(ctx, "REGISTERED", "msg")
+	in.Wrap(ctx, errors.New("inner"), "", "msg")
+	if len(handled) != 0 {
+		t.Error("registered and empty codes should be valid", handled)
+	}
+
+	err := in.New(ctx, "UNREGISTERED", "msg")
+	if err == nil || err.Error() != "msg" {
+		t.Error("the error should still be created", err)
+	}
+	if len(handled) != 1 {
+		t.Fatal("unregistered code should be handled", handled)
+	}
+	if code, _ := in.GetCode(handled[0]); code != ctxerr.CodeUnregistered {
+		t.Error("code did not match", code)
+	}
+	if v := in.AllFields(handled[0])[ctxerr.FieldKeyUnregisteredCode]; v != "UNREGISTERED" {
+		t.Error("unregistered code field did not match", v)
+	}
+
+	codes := in.RegisteredCodes()
+	if !reflect.DeepEqual(codes, map[string]string{"REGISTERED": "a registered code"}) {
+		t.Error("registered codes did not match", codes)
+	}
+	codes["OTHER"] = ""
+	if len(in.RegisteredCodes()) != 1 {
+		t.Error("registered codes should be a copy")
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		in := ctxerr.NewInstance()
+		in.PanicOnUnregisteredCode = true
+		in.AddCreateHook(in.ValidateRegisteredCodeHook)
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("unregistered code should panic")
+			}
+		}()
+		in.New(ctx, "UNREGISTERED", "msg")
+	})
+}
+
+func TestGlobalRegisterCode(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	ctxerr.RegisterCode("GLOBAL_REGISTERED", "a registered code")
+	if _, ok := ctxerr.RegisteredCodes()["GLOBAL_REGISTERED"]; !ok {
+		t.Error("code was not registered")
+	}
+	ctx := ctxerr.ValidateRegisteredCodeHook(context.Background(), "GLOBAL_REGISTERED", nil)
+	if ctx == nil {
+		t.Error("context should be returned")
+	}
+}
+
+func TestWithCode(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	err := ctxerr.New(ctx, "", "msg")
+
+	coded := ctxerr.WithCode(err, "CODE")
+	if coded != err {
+		t.Error("a CtxErr should be returned without wrapping")
+	}
+	f := ctxerr.AllFields(coded)
+	if f[ctxerr.FieldKeyCode] != "CODE" || f["a"] != "a" {
+		t.Error("fields did not match", f)
+	}
+	if locs := f[ctxerr.FieldKeyLocation].([]any); len(locs) != 1 {
+		t.Error("location should not be added", locs)
+	}
+
+	plain := errors.New("plain")
+	coded = ctxerr.WithCode(plain, "CODE")
+	if coded.Error() != "plain" || !errors.Is(coded, plain) {
+		t.Error("other errors should be wrapped", coded)
+	}
+	if f := ctxerr.AllFields(coded); !reflect.DeepEqual(f, map[string]any{ctxerr.FieldKeyCode: "CODE"}) {
+		t.Error("only the code should be added", f)
 	}
 
-	category := "category"
-	ctx = ctxerr.SetCategory(ctx, category)
-	if v := ctxerr.Fields(ctx)[ctxerr.FieldKeyCategory]; v != category {
-		t.Error("SetCategory did work as expected: ", v)
+	// A deeper code still wins in AllFields
+	inner := ctxerr.New(context.Background(), "INNER", "inner")
+	err = ctxerr.WithCode(ctxerr.QuickWrap(context.Background(), inner), "OUTER")
+	if v := ctxerr.AllFields(err)[ctxerr.FieldKeyCode]; v != "INNER" {
+		t.Error("deeper code should win in AllFields", v)
+	}
+	if code, _ := ctxerr.GetCode(err); code != "OUTER" {
+		t.Error("outermost code did not match", code)
+	}
+
+	if ctxerr.WithCode(nil, "CODE") != nil {
+		t.Error("nil should stay nil")
 	}
 }
 
-func TestWrappingWithNoUnderlyingCode(t *testing.T) {
-	expectedCode := "expected"
-	ctx := context.Background()
-	err := ctxerr.New(ctx, "", "0")
-	err = ctxerr.Wrap(ctx, err, expectedCode, "1")
+func TestJSONHandleHook(t *testing.T) {
+	var buf bytes.Buffer
+	hook := ctxerr.JSONHandleHook(&buf)
 
-	code := ctxerr.AllFields(err)[ctxerr.FieldKeyCode]
-	if code != expectedCode {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	hook(ctxerr.New(ctx, "CODE", "msg"))
+	hook(nil)
+	hook(errors.New("plain"))
+
+	lines := strings.Split(buf.String(), "\n")
+	if len(lines) != 3 || lines[2] != "" {
+		t.Fatal("should be one line per error with a trailing newline", lines)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatal("invalid JSON", err, lines[0])
+	}
+	if m["message"] != "msg" || m["a"] != "a" || m[ctxerr.FieldKeyCode] != "CODE" {
+		t.Error("fields did not match", m)
+	}
+
+	m = nil
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil {
+		t.Fatal("invalid JSON", err, lines[1])
+	}
+	delete(m, "time")
+	if !reflect.DeepEqual(m, map[string]any{"message": "plain"}) {
+		t.Error("fields did not match", m)
+	}
+}
+
+func TestJSONHandleHookTime(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.Now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	var buf bytes.Buffer
+	in.JSONHandleHook(&buf)(errors.New("msg"))
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal("invalid JSON", err, buf.String())
+	}
+	if m["time"] != "2024-01-02T03:04:05Z" {
+		t.Error("time did not match", m["time"])
+	}
+
+	// The global is read when the hook runs
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	buf.Reset()
+	hook := ctxerr.JSONHandleHook(&buf)
+	ctxerr.SetGlobal(in)
+	hook(errors.New("msg"))
+	if !strings.Contains(buf.String(), `"time":"2024-01-02T03:04:05Z"`) {
+		t.Error("the instance set after creating the hook was not used", buf.String())
+	}
+}
+
+func TestWrapAll(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	b := errors.New("b")
+	c := errors.New("c")
+
+	if err := ctxerr.WrapAll(ctx, "CODE", nil, nil); err != nil {
+		t.Error("all nil should be nil", err)
+	}
+
+	err := ctxerr.WrapAll(ctx, "CODE", nil, b)
+	if err.Error() != "b" || !errors.Is(err, b) {
+		t.Error("single error did not match", err)
+	}
+	if code, _ := ctxerr.GetCode(err); code != "CODE" {
 		t.Error("code did not match", code)
 	}
+
+	err = ctxerr.WrapAll(ctx, "CODE", b, nil, c)
+	if err.Error() != "b\nc" || !errors.Is(err, b) || !errors.Is(err, c) {
+		t.Error("joined error did not match", err)
+	}
+	x, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(x.Unwrap()) != 2 {
+		t.Fatal("should be joined")
+	}
+	for _, e := range x.Unwrap() {
+		f := ctxerr.AllFields(e)
+		if f[ctxerr.FieldKeyCode] != "CODE" || f["a"] != "a" {
+			t.Error("each error should be wrapped", f)
+		}
+		if v := f[ctxerr.FieldKeyLocation]; !reflect.DeepEqual(v, []any{"ctxerr_test.TestWrapAll"}) {
+			t.Error("location did not match", v)
+		}
+	}
 }
 
-func TestHTTPFuncs(t *testing.T) {
+func TestFieldsDiff(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.CreateHooks = nil
+	ctx := context.Background()
+
+	a := in.New(in.SetFields(ctx, map[string]any{"same": 1, "changed": 1, "removed": 1, "nil": nil, "slice": []int{1}}), "")
+	b := in.New(in.SetFields(ctx, map[string]any{"same": 1, "changed": 2, "added": 2, "nil": nil, "slice": []int{1}}), "")
+
+	expected := map[string][2]any{
+		"changed": {1, 2},
+		"removed": {1, ctxerr.FieldAbsent},
+		"added":   {ctxerr.FieldAbsent, 2},
+	}
+	if diff := in.FieldsDiff(a, b); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("diff did not match \n%v\n%v", diff, expected)
+	}
+
+	c := in.New(in.SetField(ctx, "nil", "not nil"), "")
+	expected = map[string][2]any{"nil": {"not nil", ctxerr.FieldAbsent}}
+	if diff := in.FieldsDiff(c, nil); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("diff did not match \n%v\n%v", diff, expected)
+	}
+
+	if diff := ctxerr.FieldsDiff(nil, nil); len(diff) != 0 {
+		t.Error("nil errors should have no diff", diff)
+	}
+}
+
+func TestHandleReturnsHooked(t *testing.T) {
+	in := ctxerr.NewInstance()
+	err := errors.New("err")
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	if in.Handle(err) {
+		t.Error("default log hook should return false")
+	}
+	if in.Handle(nil) {
+		t.Error("nil should return false")
+	}
+
+	var handled int
+	in.AddHandleHook(func(error) { handled++ })
+	if !in.Handle(err) || handled != 1 {
+		t.Error("hook should run and return true", handled)
+	}
+	if in.Handle(nil) || handled != 1 {
+		t.Error("nil should not be handled", handled)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	if err := ctxerr.Recover(ctx, nil, "PANIC"); err != nil {
+		t.Error("nil should return nil", err)
+	}
+
+	panicErr := errors.New("panic error")
 	tests := []struct {
-		name               string
-		err                error
-		expectedCode       any
-		expectedAction     any
-		expectedStatusCode any
-		expectedMessage    string
+		name      string
+		recovered any
+		message   string
+	}{
+		{name: "string", recovered: "oops", message: "panic: oops"},
+		{name: "error", recovered: panicErr, message: "panic : panic error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			func() {
+				defer func() { err = ctxerr.Recover(ctx, recover(), "PANIC") }()
+				panic(tt.recovered)
+			}()
+
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if err.Error() != tt.message {
+				t.Error("message did not match", err.Error())
+			}
+			if e, ok := tt.recovered.(error); ok && !errors.Is(err, e) {
+				t.Error("recovered error should be wrapped")
+			}
+			if !ctxerr.HasCategory(err, ctxerr.CategoryPanic) {
+				t.Error("missing panic category")
+			}
+			f := ctxerr.AllFields(err)
+			if f[ctxerr.FieldKeyCode] != "PANIC" || f["a"] != "a" {
+				t.Error("fields did not match", f)
+			}
+			stack, _ := f[ctxerr.FieldKeyStack].([]any)
+			if len(stack) != 1 || !strings.Contains(fmt.Sprint(stack[0]), "TestRecover") {
+				t.Error("stack should include where the panic happened", stack)
+			}
+		})
+	}
+}
+
+func TestIncludeCodeInError(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		include  bool
+		err      func(in ctxerr.Instance) error
+		expected string
 	}{
 		{
-			name:               "NewHTTP",
-			err:                ctxerr.NewHTTP(context.Background(), "c", "a", http.StatusBadRequest, "m", 1, "v"),
-			expectedCode:       "c",
-			expectedAction:     "a",
-			expectedStatusCode: http.StatusBadRequest,
-			expectedMessage:    "m1v",
+			name:     "unset",
+			err:      func(in ctxerr.Instance) error { return in.Wrap(ctx, in.New(ctx, "INNER", "inner"), "OUTER", "outer") },
+			expected: "outer : inner",
 		},
 		{
-			name:               "NewHTTPf",
-			err:                ctxerr.NewHTTPf(context.Background(), "c", "a", http.StatusConflict, "m %02d%s", 1, "v"),
-			expectedCode:       "c",
-			expectedAction:     "a",
-			expectedStatusCode: http.StatusConflict,
-			expectedMessage:    "m 01v",
+			name:     "set",
+			include:  true,
+			err:      func(in ctxerr.Instance) error { return in.Wrap(ctx, in.New(ctx, "INNER", "inner"), "OUTER", "outer") },
+			expected: "[OUTER] outer : [INNER] inner",
 		},
 		{
-			name:               "WrapHTTP",
-			err:                ctxerr.WrapHTTP(context.Background(), fmt.Errorf("e"), "c", "a", 0, "m", 1, "v"),
-			expectedCode:       "c",
-			expectedAction:     "a",
-			expectedStatusCode: nil,
-			expectedMessage:    "m1v : e",
+			name:     "no code",
+			include:  true,
+			err:      func(in ctxerr.Instance) error { return in.QuickWrap(ctx, in.Newf(ctx, "", "inner %d", 1)) },
+			expected: "inner 1",
 		},
 		{
-			name:               "WrapHTTPf",
-			err:                ctxerr.WrapHTTPf(context.Background(), fmt.Errorf("e"), "c", "a", http.StatusBadRequest, "m %02d%s", 1, "v"),
-			expectedCode:       "c",
-			expectedAction:     "a",
-			expectedStatusCode: http.StatusBadRequest,
-			expectedMessage:    "m 01v : e",
+			name:     "no message",
+			include:  true,
+			err:      func(in ctxerr.Instance) error { return in.Wrap(ctx, in.New(ctx, "INNER"), "OUTER") },
+			expected: "[OUTER] [INNER]",
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := ctxerr.NewInstance()
+			in.IncludeCodeInError = tt.include
+			if v := tt.err(in).Error(); v != tt.expected {
+				t.Errorf("message did not match '%s' '%s'", v, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsCode(t *testing.T) {
+	ctx := context.Background()
+	a := ctxerr.New(ctx, "CODE_A", "a")
+	b := ctxerr.New(ctx, "CODE_B", "b")
+
+	tests := []struct {
+		name     string
+		err      error
+		code     string
+		expected bool
+	}{
+		{name: "nil", err: nil, code: "CODE_A", expected: false},
+		{name: "plain", err: errors.New("CODE_A"), code: "CODE_A", expected: false},
+		{name: "outer", err: ctxerr.Wrap(ctx, a, "OUTER"), code: "OUTER", expected: true},
+		{name: "inner", err: ctxerr.Wrap(ctx, a, "OUTER"), code: "CODE_A", expected: true},
+		{name: "joined branch", err: errors.Join(errors.New("plain"), b), code: "CODE_B", expected: true},
+		{name: "wrapped joined branch", err: ctxerr.Wrap(ctx, errors.Join(a, b), "OUTER"), code: "CODE_B", expected: true},
+		{name: "missing", err: ctxerr.Wrap(ctx, errors.Join(a, b), "OUTER"), code: "CODE_C", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := ctxerr.IsCode(tt.err, tt.code); v != tt.expected {
+				t.Error("did not match", v, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFieldKeyMap(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.SetFieldKeyPrefix("err.")
+	in.IncludeCodeInError = true
+
+	if k := in.FieldKey(ctxerr.FieldKeyCode); k != "err.code" {
+		t.Error("key did not match", k)
+	}
+	if k := in.FieldKey("other"); k != "other" {
+		t.Error("unmapped key should be the same", k)
+	}
+
+	ctx := in.SetCategory(context.Background(), ctxerr.CategoryNotFound)
+	inner := in.New(ctx, "INNER", "inner")
+	err := in.Wrap(context.Background(), inner, "OUTER", "outer")
+
+	f := in.AllFields(err)
+	expected := map[string]any{
+		"err.code":     "INNER",
+		"err.category": ctxerr.CategoryNotFound,
+		"err.location": []any{"ctxerr_test.TestFieldKeyMap", "ctxerr_test.TestFieldKeyMap"},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Errorf("fields did not match \n%#v\n%#v", f, expected)
+	}
+
+	if code, _ := in.GetCode(err); code != "OUTER" {
+		t.Error("code did not match", code)
+	}
+	if !in.HasCode(err, "INNER") || !in.HasCategory(err, ctxerr.CategoryNotFound) {
+		t.Error("code and category should be found with the mapped keys")
+	}
+	if info, _ := in.Inspect(err); info.Code != "INNER" || info.Category != ctxerr.CategoryNotFound {
+		t.Error("info did not match", info)
+	}
+	if msg := err.Error(); msg != "[OUTER] outer : [INNER] inner" {
+		t.Error("message should include the mapped code", msg)
+	}
+}
+
+func TestOutermostFields(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.CreateHooks = nil
+
+	inner := in.New(in.SetField(context.Background(), "a", "a"), "", "inner")
+	err := in.Wrap(in.SetField(context.Background(), "b", "b"), inner, "", "outer")
+
+	if f := ctxerr.OutermostFields(err); !reflect.DeepEqual(f, map[string]any{"b": "b"}) {
+		t.Error("fields did not match", f)
+	}
+	if f := ctxerr.AllFields(err); len(f) != 2 {
+		t.Error("all fields should have both", f)
+	}
+	if f := ctxerr.OutermostFields(errors.New("plain")); len(f) != 0 {
+		t.Error("plain errors should have no fields", f)
+	}
+}
+
+func TestHandleCtx(t *testing.T) {
+	in := ctxerr.NewInstance()
+
+	var handled []error
+	in.AddHandleHook(func(err error) { handled = append(handled, err) })
+	var tenants []any
+	in.AddHandleHookCtx(func(ctx context.Context, err error) {
+		tenants = append(tenants, ctx.Value(testContextKey("tenant")))
+	})
+
+	errCtx := context.WithValue(context.Background(), testContextKey("tenant"), "error tenant")
+	err := in.New(errCtx, "CODE", "msg")
+
+	handleCtx := context.WithValue(context.Background(), testContextKey("tenant"), "handle tenant")
+	if !in.HandleCtx(handleCtx, err) {
+		t.Error("hooks should run")
+	}
+	if !in.Handle(err) {
+		t.Error("hooks should run")
+	}
+	if !in.Handle(errors.New("plain")) {
+		t.Error("hooks should run")
+	}
+
+	if len(handled) != 3 {
+		t.Error("error hooks should run for both forms", handled)
+	}
+	if expected := []any{"handle tenant", "error tenant", nil}; !reflect.DeepEqual(tenants, expected) {
+		t.Error("contexts did not match", tenants, expected)
+	}
+
+	in = ctxerr.NewInstance()
+	in.AddHandleHookCtx(func(context.Context, error) {})
+	if !in.Handle(err) {
+		t.Error("only a context hook should still count as a hook")
+	}
+}
+
+func TestGlobalAddHandleHookCtx(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	type key struct{}
+	var got any
+	ctxerr.AddHandleHookCtx(func(ctx context.Context, err error) { got = ctx.Value(key{}) })
+	ctx := context.WithValue(context.Background(), key{}, "handled")
+	if !ctxerr.HandleCtx(ctx, ctxerr.New(context.Background(), "CODE")) || got != "handled" {
+		t.Error("the hook should run with the context it was handled with", got)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected to recover")
+			}
+		}()
+		var in *ctxerr.Instance
+		in.AddHandleHookCtx(func(context.Context, error) {})
+	}()
+}
+
+func TestMaxUnwrapDepth(t *testing.T) {
+	in := ctxerr.NewInstance()
+	ctx := in.SetCategory(in.SetField(context.Background(), "bottom", true), "deep")
+	err := in.New(ctx, "CODE")
+	for i := 0; i < 1000; i++ {
+		err = in.Wrap(in.SetField(context.Background(), "layer", i), err, "")
+	}
+
+	if !in.HasField(err, "bottom") || !in.HasCategory(err, "deep") {
+		t.Error("unlimited depth should find the bottom error")
+	}
+
+	in.MaxUnwrapDepth = 10
+	f := in.AllFields(err)
+	if f["layer"] != 990 {
+		t.Error("walk should stop at the cap", f["layer"])
+	}
+	if _, ok := f["bottom"]; ok {
+		t.Error("the bottom error should not be reached")
+	}
+	if in.HasField(err, "bottom") {
+		t.Error("HasField should stop at the cap")
+	}
+	if !in.HasField(err, "layer") {
+		t.Error("HasField should still find fields within the cap")
+	}
+	if in.HasCategory(err, "deep") {
+		t.Error("HasCategory should stop at the cap")
+	}
+
+	in.MaxUnwrapDepth = 2
+	a := in.New(context.Background(), "A")
+	b := in.New(in.SetCategory(in.SetField(context.Background(), "k", "k"), "b"), "B")
+	joined := in.Wrap(context.Background(), errors.Join(a, b), "")
+	if _, ok := in.AllFields(joined)["k"]; !ok || !in.HasField(joined, "k") || !in.HasCategory(joined, "b") {
+		t.Error("joined branches should be at the same depth for every function", in.AllFields(joined))
+	}
+}
+
+func TestMaxWrapDepth(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.MaxWrapDepth = 3
+
+	err := in.New(context.Background(), "CODE", "base")
+	for i := 0; i < 10; i++ {
+		err = in.Wrap(context.Background(), err, "", "wrap")
+		err = in.Wrapf(context.Background(), err, "", "wrapf %d", i)
+	}
+
+	depth := 0
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		depth++
+	}
+	if depth != 3 {
+		t.Error("wrapping should stop at the max depth", depth)
+	}
+	if expected := "wrapf 0 : wrap : base"; err.Error() != expected {
+		t.Errorf("message did not match '%s' '%s'", err.Error(), expected)
+	}
+}
+
+func TestFieldEncoder(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.FieldEncoder = func(f map[string]any) ([]byte, error) {
+		if _, ok := f["fail"]; ok {
+			return nil, errors.New("encoder failed")
+		}
+		return []byte(fmt.Sprintf("encoded %v", f["foo"])), nil
+	}
+
+	sb := &strings.Builder{}
+	log.SetOutput(sb)
+	defer log.SetOutput(os.Stderr)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(flags)
+
+	ctx := in.SetField(context.Background(), "foo", "bar")
+	in.DefaultLogHook(in.New(ctx, "code", "msg"))
+	if out, expected := strings.TrimSpace(sb.String()), "msg - encoded bar"; out != expected {
+		t.Errorf("log did not match\n%s\n%s", out, expected)
+	}
+
+	sb.Reset()
+	in.DefaultLogHook(in.New(in.SetField(ctx, "fail", true), "code", "msg"))
+	if out := sb.String(); !strings.Contains(out, "could not be encoded: encoder failed") {
+		t.Error("encoder error should be logged", out)
+	}
+
+	var buf bytes.Buffer
+	in.JSONHandleHook(&buf)(in.New(ctx, "code", "msg"))
+	if out, expected := buf.String(), "encoded bar\n"; out != expected {
+		t.Errorf("hook output did not match\n%s\n%s", out, expected)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := ctxerr.SetRetryable(context.Background(), true)
+	ctx = ctxerr.SetRetryAfter(ctx, time.Second)
+	inner := ctxerr.New(ctx, "CODE")
+
+	tests := []struct {
+		name              string
+		err               error
+		retryable         bool
+		retryAfter        time.Duration
+		expectedRetryHint bool
+	}{
+		{name: "nil"},
+		{name: "plain", err: errors.New("plain")},
+		{name: "no fields", err: ctxerr.New(context.Background(), "CODE")},
+		{name: "set", err: inner, retryable: true, retryAfter: time.Second, expectedRetryHint: true},
+		{name: "wrapped", err: ctxerr.Wrap(context.Background(), inner, ""), retryable: true, retryAfter: time.Second, expectedRetryHint: true},
 		{
-			name:               "WrapHTTP nil error",
-			err:                ctxerr.WrapHTTP(context.Background(), nil, "c", "a", 0, "m", 1, "v"),
-			expectedCode:       nil,
-			expectedAction:     nil,
-			expectedStatusCode: nil,
-			expectedMessage:    "ignored",
+			name:              "outermost wins",
+			err:               ctxerr.Wrap(ctxerr.SetRetryAfter(ctxerr.SetRetryable(context.Background(), false), time.Minute), inner, ""),
+			retryable:         false,
+			retryAfter:        time.Minute,
+			expectedRetryHint: true,
 		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := ctxerr.IsRetryable(tt.err); v != tt.retryable {
+				t.Error("retryable did not match", v, tt.retryable)
+			}
+			d, ok := ctxerr.RetryAfter(tt.err)
+			if d != tt.retryAfter || ok != tt.expectedRetryHint {
+				t.Error("retry after did not match", d, ok, tt.retryAfter, tt.expectedRetryHint)
+			}
+		})
+	}
+}
+
+func TestWrapkv(t *testing.T) {
+	base := errors.New("base")
+	ctx := ctxerr.SetField(context.Background(), "ctx", "ctx")
+
+	tests := []struct {
+		name     string
+		kvs      []any
+		expected map[string]any
+	}{
 		{
-			name:               "WrapHTTPf nil error",
-			err:                ctxerr.WrapHTTPf(context.Background(), nil, "c", "a", http.StatusBadRequest, "m %02d%s", 1, "v"),
-			expectedCode:       nil,
-			expectedAction:     nil,
-			expectedStatusCode: nil,
-			expectedMessage:    "ignored",
+			name:     "none",
+			expected: map[string]any{"ctx": "ctx"},
 		},
 		{
-			name: "Status-code-already-on-context",
-			err: func() error {
-				ctx := ctxerr.SetHTTPStatusCode(context.Background(), http.StatusInternalServerError)
-				return ctxerr.NewHTTP(ctx, "c", "a", http.StatusBadRequest, "m")
-			}(),
-			expectedCode:       "c",
-			expectedAction:     "a",
-			expectedStatusCode: http.StatusBadRequest,
-			expectedMessage:    "m",
+			name:     "even",
+			kvs:      []any{"a", 1, "b", "two"},
+			expected: map[string]any{"ctx": "ctx", "a": 1, "b": "two"},
 		},
 		{
-			name: "Wrapped error already has status code and action",
-			err: func() error {
-				err := ctxerr.NewHTTP(context.Background(), "ci", "ai", http.StatusBadRequest, "mi")
-				return ctxerr.WrapHTTP(context.Background(), err, "co", "ao", http.StatusConflict, "mo")
-			}(),
-			expectedCode:       "ci",
-			expectedAction:     "ai",
-			expectedStatusCode: http.StatusBadRequest,
-			expectedMessage:    "mo : mi",
+			name:     "odd",
+			kvs:      []any{"a", 1, "dangling"},
+			expected: map[string]any{"ctx": "ctx", "a": 1, ctxerr.BadKey: "dangling"},
 		},
 		{
-			name: "Wrapped error already has status code and action same context",
-			err: func() error {
-				ctx := context.Background()
-				err := ctxerr.NewHTTP(ctx, "ci", "ai", http.StatusBadRequest, "mi")
-				return ctxerr.WrapHTTP(ctx, err, "co", "ao", http.StatusConflict, "mo")
-			}(),
-			expectedCode:       "ci",
-			expectedAction:     "ai",
-			expectedStatusCode: http.StatusBadRequest,
-			expectedMessage:    "mo : mi",
+			name:     "non string key",
+			kvs:      []any{2, "a", 1},
+			expected: map[string]any{"ctx": "ctx", "a": 1, ctxerr.BadKey: 2},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			f := ctxerr.AllFields(tt.err)
-
-			if v := f[ctxerr.FieldKeyCode]; v != tt.expectedCode {
-				t.Errorf("code did not match: %v", v)
-			}
-			if v := f[ctxerr.FieldKeyAction]; v != tt.expectedAction {
-				t.Errorf("action did not match: %v - %v", v, tt.expectedAction)
+			err := ctxerr.Wrapkv(ctx, base, "CODE", tt.kvs...)
+			if !errors.Is(err, base) {
+				t.Error("should wrap the error")
 			}
-			if v := f[ctxerr.FieldKeyStatusCode]; v != tt.expectedStatusCode {
-				t.Errorf("status code did not match: %v - %v", v, tt.expectedStatusCode)
+
+			f := ctxerr.AllFields(err)
+			delete(f, ctxerr.FieldKeyCode)
+			delete(f, ctxerr.FieldKeyLocation)
+			if !reflect.DeepEqual(f, tt.expected) {
+				t.Error("fields did not match", f, tt.expected)
 			}
-			if tt.err != nil {
-				if v := tt.err.Error(); v != tt.expectedMessage {
-					t.Errorf("message did not match: %v - %v", v, tt.expectedMessage)
+		})
+	}
+
+	if err := ctxerr.Wrapkv(ctx, nil, "CODE", "a", 1); err != nil {
+		t.Error("nil should not be wrapped", err)
+	}
+}
+
+func TestPerLeafHandleHook(t *testing.T) {
+	var handled []error
+	hook := ctxerr.PerLeafHandleHook(func(err error) { handled = append(handled, err) })
+
+	a := ctxerr.New(ctxerr.SetField(context.Background(), "leaf", "a"), "A", "a")
+	b := ctxerr.New(ctxerr.SetField(context.Background(), "leaf", "b"), "B", "b")
+	c := errors.New("c")
+	ctx := ctxerr.SetField(context.Background(), "outer", "outer")
+	err := ctxerr.Wrap(ctx, errors.Join(a, errors.Join(b, nil, c)), "OUTER", "outer")
+
+	hook(err)
+	if len(handled) != 3 {
+		t.Fatal("should handle each leaf", len(handled))
+	}
+
+	expected := []struct {
+		code, leaf, msg string
+		leafErr         error
+	}{
+		{"OUTER", "a", "outer : a", a},
+		{"OUTER", "b", "outer : b", b},
+		{"OUTER", "", "outer : c", c},
+	}
+	for i, e := range expected {
+		f := ctxerr.AllFields(handled[i])
+		if leaf, _ := f["leaf"].(string); f["outer"] != "outer" || leaf != e.leaf {
+			t.Error("fields did not match", i, f)
+		}
+		if code, _ := ctxerr.GetCode(handled[i]); code != e.code {
+			t.Error("code did not match", i, code)
+		}
+		if handled[i].Error() != e.msg {
+			t.Error("message did not match", i, handled[i])
+		}
+		if !errors.Is(handled[i], e.leafErr) {
+			t.Error("should wrap the leaf", i)
+		}
+	}
+
+	handled = nil
+	hook(a)
+	hook(nil)
+	if len(handled) != 1 || handled[0] != a {
+		t.Error("errors that are not joined should be handled once", handled)
+	}
+}
+
+func TestSetFromContextHook(t *testing.T) {
+	type requestIDKey struct{}
+
+	in := ctxerr.NewInstance()
+	in.AddCreateHook(in.SetFromContextHook(requestIDKey{}, "request_id"))
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	err := in.New(ctx, "CODE")
+	if v := in.AllFields(err)["request_id"]; v != "req-1" {
+		t.Error("request ID did not match", v)
+	}
+
+	err = in.Wrap(context.Background(), err, "WRAP")
+	if v := in.AllFields(err)["request_id"]; v != "req-1" {
+		t.Error("request ID should still be on the wrapped error", v)
+	}
+
+	if in.HasField(in.New(context.Background(), "CODE"), "request_id") {
+		t.Error("a context without the value should not set the field")
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	ctx = ctxerr.SetField(ctx, "b", "b")
+	err := ctxerr.New(ctx, "CODE", "msg")
+
+	if added := ctxerr.AddFields(err, map[string]any{"b": "replaced", "c": "c"}); added != err {
+		t.Error("a CtxErr should not be wrapped")
+	}
+	f := ctxerr.AllFields(err)
+	for k, v := range map[string]any{"a": "a", "b": "replaced", "c": "c", ctxerr.FieldKeyCode: "CODE"} {
+		if f[k] != v {
+			t.Error("field did not match", k, f[k], v)
+		}
+	}
+
+	plain := errors.New("plain")
+	err = ctxerr.AddFields(plain, map[string]any{"c": "c"})
+	if !errors.Is(err, plain) || err.Error() != "plain" {
+		t.Error("plain error should be wrapped without a message", err)
+	}
+	if f := ctxerr.AllFields(err); !reflect.DeepEqual(f, map[string]any{"c": "c"}) {
+		t.Error("fields did not match", f)
+	}
+
+	if ctxerr.AddFields(nil, map[string]any{"c": "c"}) != nil {
+		t.Error("nil should stay nil")
+	}
+}
+
+func TestMustCode(t *testing.T) {
+	format := ctxerr.NewInstance()
+	format.CodeFormat = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+	registry := ctxerr.NewInstance()
+	registry.RegisterCode("NOT_FOUND", "the thing does not exist")
+
+	tests := []struct {
+		name        string
+		in          ctxerr.Instance
+		code        string
+		expectPanic bool
+	}{
+		{name: "any", in: ctxerr.NewInstance(), code: "not found"},
+		{name: "empty", in: ctxerr.NewInstance(), code: "", expectPanic: true},
+		{name: "format", in: format, code: "NOT_FOUND"},
+		{name: "bad format", in: format, code: "not found", expectPanic: true},
+		{name: "registered", in: registry, code: "NOT_FOUND"},
+		{name: "not registered", in: registry, code: "CONFLICT", expectPanic: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); (r != nil) != tt.expectPanic {
+					t.Error("panic did not match", r)
 				}
+			}()
+			if code := tt.in.MustCode(tt.code); code != tt.code {
+				t.Error("code did not match", code)
 			}
 		})
 	}
 }
 
-func TestHasField(t *testing.T) {
-	ctx := context.Background()
-	err := ctxerr.New(ctx, "c")
+func TestWrapCause(t *testing.T) {
+	base := errors.New("not found")
+	cause := ctxerr.New(ctxerr.SetField(context.Background(), "query", "select"), "DB", "connection reset")
 
-	if !ctxerr.HasField(err, ctxerr.FieldKeyCode) {
-		t.Error("expected code field", ctxerr.FieldKeyCode, ctxerr.AllFields(err))
+	err := ctxerr.WrapCause(context.Background(), base, cause, "CODE", "lookup failed")
+	if expected := "lookup failed : not found"; err.Error() != expected {
+		t.Errorf("message did not match '%s' '%s'", err.Error(), expected)
+	}
+	if !errors.Is(err, base) {
+		t.Error("the error should be wrapped")
 	}
 
-	key := "key"
-	if ctxerr.HasField(err, key) {
-		t.Error("expected no field", key, ctxerr.AllFields(err))
+	c, ok := ctxerr.AllFields(err)[ctxerr.FieldKeyCause].(map[string]any)
+	if !ok {
+		t.Fatal("cause should be a field", ctxerr.AllFields(err))
+	}
+	if c["message"] != "connection reset" {
+		t.Error("cause message did not match", c["message"])
+	}
+	if f, _ := c["fields"].(map[string]any); f["query"] != "select" || f[ctxerr.FieldKeyCode] != "DB" {
+		t.Error("cause fields did not match", c["fields"])
 	}
 
-	ctx = ctxerr.SetField(ctx, key, "")
-	err = ctxerr.Wrap(context.Background(), ctxerr.Wrap(ctx, fmt.Errorf(""), "c"), "c")
+	plain := errors.New("plain")
+	err = ctxerr.WrapCause(context.Background(), base, plain, "CODE")
+	if errors.Is(err, plain) {
+		t.Error("the cause should not be in the tree")
+	}
+	if c := ctxerr.AllFields(err)[ctxerr.FieldKeyCause]; !reflect.DeepEqual(c, map[string]any{"message": "plain"}) {
+		t.Error("plain cause did not match", c)
+	}
 
-	if !ctxerr.HasField(err, key) {
-		t.Error("expected field", key, ctxerr.AllFields(err))
+	if ctxerr.HasField(ctxerr.WrapCause(context.Background(), base, nil, "CODE"), ctxerr.FieldKeyCause) {
+		t.Error("nil cause should not add a field")
 	}
+	if ctxerr.WrapCause(context.Background(), nil, cause, "CODE") != nil {
+		t.Error("nil should not be wrapped")
+	}
+}
 
-	err = fmt.Errorf("abc")
-	if ctxerr.HasField(err, ctxerr.FieldKeyCode) {
-		t.Error("expected no code field", ctxerr.FieldKeyCode, ctxerr.AllFields(err))
+func TestQuiet(t *testing.T) {
+	sb := &strings.Builder{}
+	log.SetOutput(sb)
+	defer log.SetOutput(os.Stderr)
+
+	in := ctxerr.NewInstance()
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "normal", err: in.New(context.Background(), "CODE"), expected: true},
+		{name: "quiet", err: in.New(in.SetQuiet(context.Background(), true), "CODE")},
+		{name: "wrapped quiet", err: in.Wrap(context.Background(), in.New(in.SetQuiet(context.Background(), true), "CODE"), "WRAP")},
+		{name: "outer not quiet", err: in.Wrap(in.SetQuiet(context.Background(), false), in.New(in.SetQuiet(context.Background(), true), "CODE"), "WRAP"), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sb.Reset()
+			in.Handle(tt.err)
+			if logged := sb.Len() > 0; logged != tt.expected {
+				t.Error("logging did not match", logged, sb.String())
+			}
+		})
 	}
 }
 
-func TestImpossibilties(t *testing.T) {
-	// Main field key is not the a map
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, ctxerr.FieldsKey, "s")
-	f := ctxerr.Fields(ctx)
-	if f != nil {
-		t.Error("expected fields to be nil")
+func TestSetGlobal(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	in := ctxerr.NewInstance()
+	in.DefaultCode = "SWAPPED"
+	in.AddCreateHook(func(ctx context.Context, code string, wrapping error) context.Context {
+		return ctxerr.SetField(ctx, "swapped", true)
+	})
+	ctxerr.SetGlobal(in)
+
+	err := ctxerr.New(context.Background(), "")
+	if code, _ := ctxerr.GetCode(err); code != "SWAPPED" {
+		t.Error("code should come from the swapped global", code)
+	}
+	if !ctxerr.HasField(err, "swapped") {
+		t.Error("hooks should come from the swapped global")
+	}
+
+	ctxerr.AddCreateHook(func(ctx context.Context, code string, wrapping error) context.Context {
+		return ctxerr.SetField(ctx, "added", true)
+	})
+	if len(in.CreateHooks) != len(ctxerr.Global().CreateHooks)-1 {
+		t.Error("adding to the global should not change the instance it was set from")
+	}
+
+	ctxerr.SetGlobal(saved)
+	err = ctxerr.New(context.Background(), "")
+	if ctxerr.HasField(err, "swapped") || ctxerr.HasField(err, "added") {
+		t.Error("restoring should remove the swapped hooks")
+	}
+
+	copied := ctxerr.Global()
+	copied.SetFieldKeyPrefix("copied_")
+	copied.RegisterCode("COPIED", "only on the copy")
+	if ctxerr.FieldKey(ctxerr.FieldKeyCode) != ctxerr.FieldKeyCode {
+		t.Error("changing a copy should not change the global key map")
 	}
+	if _, ok := ctxerr.RegisteredCodes()["COPIED"]; ok {
+		t.Error("changing a copy should not change the global codes")
+	}
+
+	fresh := ctxerr.NewInstance()
+	ctxerr.SetGlobal(fresh)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ctxerr.SetGlobal(fresh)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = ctxerr.New(context.Background(), "CODE")
+		}()
+	}
+	wg.Wait()
 }
 
-func TestImpl(t *testing.T) {
-	var e1 ctxerr.CtxErr = ctxerr.New(context.Background(), "c1").(ctxerr.CtxErr)
-	var e2 ctxerr.CtxErr = ctxerr.New(context.Background(), "c2").(ctxerr.CtxErr)
-	if !e1.Is(e2) {
-		t.Error("expected is to work")
+func TestConcurrentGlobalHooks(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	var handled atomic.Int64
+	start := ctxerr.NewInstance()
+	start.AddHandleHook(func(error) {})
+	ctxerr.SetGlobal(start)
+	var wg sync.WaitGroup
+	n := 20
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			ctxerr.AddHandleHook(func(error) { handled.Add(1) })
+		}()
+		go func() {
+			defer wg.Done()
+			ctxerr.AddCreateHook(func(ctx context.Context, code string, wrapping error) context.Context { return ctx })
+		}()
+		go func() {
+			defer wg.Done()
+			err := ctxerr.New(context.Background(), "CODE")
+			ctxerr.AllFields(err)
+			ctxerr.Handle(err)
+		}()
 	}
+	wg.Wait()
 
-	ctx := e1.Context()
-	if code := ctxerr.Fields(ctx)[ctxerr.FieldKeyCode]; code == "" {
-		t.Error("expected a code")
+	if l := len(ctxerr.Global().HandleHooks); l != len(start.HandleHooks)+n {
+		t.Error("handle hooks were lost", l)
 	}
-	e1.WithContext(context.Background())
-	if code := ctxerr.Fields(ctx)[ctxerr.FieldKeyCode]; code == "" {
-		t.Error("expected no code got", code)
+	if l := len(ctxerr.Global().CreateHooks); l != len(start.CreateHooks)+n {
+		t.Error("create hooks were lost", l)
+	}
+
+	handled.Store(0)
+	ctxerr.Handle(errors.New("err"))
+	if v := handled.Load(); v < int64(n) {
+		t.Error("every hook should run", v)
 	}
 }
 
-func TestFeildsWithNilCtx(t *testing.T) {
-	var ctx context.Context
-	f := ctxerr.Fields(ctx)
-	if f != nil {
-		t.Error("expected a nil map")
+type testTemperature float64
+
+func TestFieldValueMarshalers(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.FieldValueMarshalers = map[reflect.Type]func(any) any{
+		reflect.TypeOf(time.Duration(0)): func(v any) any { return v.(time.Duration).String() },
+		reflect.TypeOf(testTemperature(0)): func(v any) any {
+			return map[string]any{"value": float64(v.(testTemperature)), "unit": "C"}
+		},
+	}
+	in.FieldsAsSlice = append(in.FieldsAsSlice, "temps")
+
+	ctx := in.SetField(context.Background(), "duration", 1500*time.Millisecond)
+	ctx = in.SetField(ctx, "temp", testTemperature(21.5))
+	ctx = in.SetField(ctx, "temps", testTemperature(1))
+	ctx = in.SetField(ctx, "int", 1)
+	err := in.Wrap(in.SetField(context.Background(), "temps", testTemperature(2)), in.New(ctx, "CODE"), "")
+
+	f := in.AllFields(err)
+	expected := map[string]any{
+		"duration": "1.5s",
+		"temp":     map[string]any{"value": 21.5, "unit": "C"},
+		"temps":    []any{map[string]any{"value": 2.0, "unit": "C"}, map[string]any{"value": 1.0, "unit": "C"}},
+		"int":      1,
+	}
+	for k, v := range expected {
+		if !reflect.DeepEqual(f[k], v) {
+			t.Error("field did not match", k, f[k], v)
+		}
 	}
 }
 
-type redactable string
+var errTestNotFound = ctxerr.Sentinel("NOT_FOUND", "resource not found")
 
-func (r redactable) Redact() any {
-	return "redacted"
+func TestSentinel(t *testing.T) {
+	if errTestNotFound.Error() != "resource not found" {
+		t.Error("message did not match", errTestNotFound)
+	}
+	if ctxerr.HasField(errTestNotFound, ctxerr.FieldKeyLocation) {
+		t.Error("a sentinel should not have a location")
+	}
+
+	notFound := ctxerr.New(context.Background(), "NOT_FOUND", "no user")
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "same code", err: notFound, expected: true},
+		{name: "wrapped code", err: ctxerr.Wrap(context.Background(), notFound, "LOOKUP"), expected: true},
+		{name: "fmt wrapped", err: fmt.Errorf("lookup: %w", notFound), expected: true},
+		{name: "wrapped sentinel", err: ctxerr.Wrap(context.Background(), errTestNotFound, "LOOKUP"), expected: true},
+		{name: "other code", err: ctxerr.New(context.Background(), "CONFLICT")},
+		{name: "other sentinel", err: ctxerr.Sentinel("CONFLICT", "")},
+		{name: "plain", err: errors.New("resource not found")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is := errors.Is(tt.err, errTestNotFound); is != tt.expected {
+				t.Error("errors.Is did not match", is)
+			}
+		})
+	}
 }
 
-type IRedactable interface {
-	Redact() any
+func TestSetFieldsFunc(t *testing.T) {
+	ctx := ctxerr.SetFields(context.Background(), map[string]any{"path": "a", "count": 1, "keep": "keep"})
+	concat := func(key string, old, new any) any {
+		switch o := old.(type) {
+		case string:
+			return o + "/" + new.(string)
+		case int:
+			return o + new.(int)
+		}
+		return new
+	}
+
+	tests := []struct {
+		name     string
+		merge    func(key string, old, new any) any
+		expected map[string]any
+	}{
+		{
+			name:     "merge",
+			merge:    concat,
+			expected: map[string]any{"path": "a/b", "count": 3, "keep": "keep", "new": "new"},
+		},
+		{
+			name:     "overwrite",
+			expected: map[string]any{"path": "b", "count": 2, "keep": "keep", "new": "new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ctxerr.SetFieldsFunc(ctx, map[string]any{"path": "b", "count": 2, "new": "new"}, tt.merge)
+			if f := ctxerr.Fields(c); !reflect.DeepEqual(f, tt.expected) {
+				t.Error("fields did not match", f, tt.expected)
+			}
+		})
+	}
+
+	if f := ctxerr.Fields(ctx); f["path"] != "a" || f["count"] != 1 {
+		t.Error("the original context should not change", f)
+	}
 }
 
-func RedactItem(ctx context.Context, a any) any {
-	if v, ok := a.(IRedactable); ok {
-		return v.Redact()
+func TestSkipDoubleHandle(t *testing.T) {
+	in := ctxerr.NewInstance()
+	var handled int
+	in.AddHandleHook(func(error) { handled++ })
+
+	err := in.New(context.Background(), "CODE")
+	in.Handle(err)
+	in.Handle(err)
+	if handled != 2 || in.IsHandled(err) {
+		t.Error("errors should not be marked or skipped by default", handled)
+	}
+
+	handled = 0
+	in.SkipDoubleHandle = true
+	if !in.Handle(err) {
+		t.Error("the first handle should run the hooks")
+	}
+	if !in.IsHandled(err) {
+		t.Error("handling should mark the error")
+	}
+	if in.Handle(err) {
+		t.Error("the second handle should be skipped")
+	}
+	if handled != 1 {
+		t.Error("hooks should run once", handled)
+	}
+
+	handled = 0
+	wrapped := in.Wrap(context.Background(), err, "WRAP")
+	if in.IsHandled(wrapped) || !in.Handle(wrapped) || handled != 1 {
+		t.Error("a new error wrapping a handled error should be handled", handled)
+	}
+	if in.Handle(wrapped) || handled != 1 {
+		t.Error("the wrapping error should be skipped after it is handled", handled)
+	}
+
+	plain := errors.New("plain")
+	if marked := ctxerr.MarkHandled(plain); !ctxerr.IsHandled(marked) || !errors.Is(marked, plain) {
+		t.Error("a plain error should be wrapped and marked")
+	}
+	if ctxerr.IsHandled(plain) {
+		t.Error("a plain error should not be handled")
+	}
+
+	handled = 0
+	in.Handle(plain)
+	in.Handle(plain)
+	if handled != 2 {
+		t.Error("a plain error cannot be marked in place so it is handled each time", handled)
+	}
+
+	handled = 0
+	marked := in.MarkHandled(plain)
+	if in.Handle(marked) || handled != 0 {
+		t.Error("a plain error from MarkHandled should be skipped", handled)
+	}
+	if in.Handle(fmt.Errorf("wrapped: %w", marked)) || handled != 0 {
+		t.Error("wrapping a marked plain error should still be skipped", handled)
 	}
-	return a
 }
 
-func TestFieldHook(t *testing.T) {
-	in := ctxerr.Instance{}
-	in.AddFieldHook(RedactItem)
+func TestLevel(t *testing.T) {
+	sb := &strings.Builder{}
+	log.SetOutput(sb)
+	defer log.SetOutput(os.Stderr)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(flags)
 
-	var key = "key"
 	tests := []struct {
-		name string
-		f    func(context.Context, any) context.Context
+		name      string
+		err       error
+		level     ctxerr.Level
+		logPrefix string
+		slogLevel string
 	}{
+		{name: "unset", err: ctxerr.New(context.Background(), "CODE", "msg"), level: ctxerr.LevelError, logPrefix: "msg - ", slogLevel: "ERROR"},
+		{name: "debug", err: ctxerr.New(ctxerr.SetLevel(context.Background(), ctxerr.LevelDebug), "CODE", "msg"), level: ctxerr.LevelDebug, logPrefix: "DEBUG msg - ", slogLevel: "DEBUG"},
+		{name: "info", err: ctxerr.New(ctxerr.SetLevel(context.Background(), ctxerr.LevelInfo), "CODE", "msg"), level: ctxerr.LevelInfo, logPrefix: "INFO msg - ", slogLevel: "INFO"},
+		{name: "warn", err: ctxerr.New(ctxerr.SetLevel(context.Background(), ctxerr.LevelWarn), "CODE", "msg"), level: ctxerr.LevelWarn, logPrefix: "WARN msg - ", slogLevel: "WARN"},
 		{
-			name: "in.SetField",
-			f: func(ctx context.Context, v any) context.Context {
-				return in.SetField(ctx, key, v)
-			},
-		},
-		{
-			name: "in.SetFields",
-			f: func(ctx context.Context, v any) context.Context {
-				return in.SetFields(ctx, map[string]any{key: v})
-			},
+			name:      "outermost wins",
+			err:       ctxerr.Wrap(ctxerr.SetLevel(context.Background(), ctxerr.LevelWarn), ctxerr.New(ctxerr.SetLevel(context.Background(), ctxerr.LevelDebug), "CODE", "msg"), "WRAP"),
+			level:     ctxerr.LevelWarn,
+			logPrefix: "WARN msg - ",
+			slogLevel: "WARN",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := redactable("hello")
-
-			ctx := context.Background()
-			ctx = tt.f(ctx, r)
+			if l := ctxerr.GetLevel(tt.err); l != tt.level {
+				t.Error("level did not match", l, tt.level)
+			}
 
-			v, ok := ctxerr.Fields(ctx)[key]
-			if !ok {
-				t.Fatal("key not set")
+			sb.Reset()
+			ctxerr.NewInstance().DefaultLogHook(tt.err)
+			if !strings.HasPrefix(sb.String(), tt.logPrefix) {
+				t.Error("log did not match", sb.String(), tt.logPrefix)
 			}
 
-			if v != "redacted" {
-				t.Error("hook did not work", v)
+			buf := &bytes.Buffer{}
+			ctxerr.SlogHandleHook(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))(tt.err)
+			m := map[string]any{}
+			if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+				t.Fatal("log was not JSON", err, buf.String())
+			}
+			if m["level"] != tt.slogLevel {
+				t.Error("slog level did not match", m["level"], tt.slogLevel)
 			}
 		})
 	}
 }
 
-func TestGlobalFieldsHook(t *testing.T) {
-	ctxerr.AddFieldHook(func(_ context.Context, a any) any { return a })
-}
+func TestMessageChain(t *testing.T) {
+	ctx := context.Background()
+	err := ctxerr.New(ctx, "BOTTOM", "bottom")
+	err = ctxerr.Wrap(ctx, err, "MIDDLE", "middle")
+	err = ctxerr.QuickWrap(ctx, err)
+	err = ctxerr.Wrap(ctx, err, "TOP", "top")
 
-type FieldError struct {
-	fields map[string]any
-	err    error
-}
+	tests := []struct {
+		name     string
+		err      error
+		expected []string
+	}{
+		{name: "nil", expected: []string{}},
+		{name: "ctxerr", err: err, expected: []string{"top", "middle", "bottom"}},
+		{name: "fmt", err: fmt.Errorf("outer: %w", err), expected: []string{"outer", "top", "middle", "bottom"}},
+		{
+			name:     "joined",
+			err:      ctxerr.Wrap(ctx, errors.Join(errors.New("a"), err), "JOIN", "join"),
+			expected: []string{"join", "a", "top", "middle", "bottom"},
+		},
+	}
 
-func (fe FieldError) Error() string {
-	return fe.err.Error()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if chain := ctxerr.MessageChain(tt.err); !reflect.DeepEqual(chain, tt.expected) {
+				t.Error("chain did not match", chain, tt.expected)
+			}
+		})
+	}
 }
 
-func (fe FieldError) Fields() map[string]any {
-	return fe.fields
-}
+func TestOmitField(t *testing.T) {
+	in := ctxerr.NewInstance()
+	var after []string
+	in.AddFieldHookWithKey(func(ctx context.Context, key string, value any) any {
+		if strings.Contains(key, "password") {
+			return ctxerr.OmitField
+		}
+		return value
+	})
+	in.AddFieldHookWithKey(func(ctx context.Context, key string, value any) any {
+		after = append(after, key)
+		return value
+	})
 
-func (fe FieldError) Unwrap() error {
-	return errors.Unwrap(fe.err)
-}
+	ctx := in.SetField(context.Background(), "password", "hunter2")
+	ctx = in.SetFields(ctx, map[string]any{"user": "u", "db_password": "secret"})
+	ctx = in.NewFieldBuilder(ctx).Set("password", "hunter2").Set("b", "b").Context()
 
-func NewFieldError(msg string, fields map[string]any) error {
-	return FieldError{
-		fields: fields,
-		err:    fmt.Errorf(msg),
+	if f := ctxerr.Fields(ctx); !reflect.DeepEqual(f, map[string]any{"user": "u", "b": "b"}) {
+		t.Error("fields did not match", f)
 	}
-}
-
-func WrapFieldError(err error, msg string, fields map[string]any) error {
-	return FieldError{
-		fields: fields,
-		err:    fmt.Errorf("%s : %w", msg, err),
+	if slices.Contains(after, "password") || slices.Contains(after, "db_password") {
+		t.Error("hooks after an omitted field should not run", after)
 	}
 }
 
-func TestAllFieldsWithMultipleTypesOfErrors(t *testing.T) {
-	var _ error = FieldError{}
-
-	err := NewFieldError("bottom", map[string]any{"a": "a"})
-	err = fmt.Errorf("fmt : %w", err)
-	ctx := ctxerr.SetField(context.Background(), "b", "b")
-	ctx = ctxerr.SetCategory(ctx, "category1")
-	err = ctxerr.Wrap(ctx, err, "CTXERR_CODE_1", "ctxerr1")
-	ctx = ctxerr.SetCategory(ctx, "category2")
-	err = ctxerr.Wrap(ctx, err, "CTXERR_CODE_2", "ctxerr2")
-	err = WrapFieldError(err, "wrapfe", map[string]any{"c": "c"})
-
-	expectedMessage := "wrapfe : ctxerr2 : ctxerr1 : fmt : bottom"
-	if msg := err.Error(); msg != expectedMessage {
-		t.Errorf("message didn't match \n'%s'\n'%s'", msg, expectedMessage)
+func TestNoCtx(t *testing.T) {
+	err := ctxerr.NewNoCtx("NEW", "new")
+	if err.Error() != "new" {
+		t.Error("message did not match", err)
+	}
+	err = ctxerr.WrapNoCtx(err, "WRAP", "wrap")
+	if err.Error() != "wrap : new" {
+		t.Error("message did not match", err)
 	}
 
-	f := ctxerr.AllFields(err)
-	expectedFields := map[string]any{
-		"a":              "a",
-		"b":              "b",
-		"c":              "c",
-		"error_code":     "CTXERR_CODE_1",
-		"error_category": "category1",
-		"error_location": []any{
-			"ctxerr_test.TestAllFieldsWithMultipleTypesOfErrors",
-			"ctxerr_test.TestAllFieldsWithMultipleTypesOfErrors",
-		},
+	if code, _ := ctxerr.GetCode(err); code != "WRAP" {
+		t.Error("code did not match", code)
 	}
-	if !reflect.DeepEqual(f, expectedFields) {
-		t.Errorf("fields didn't match \n%#v\n%#v", f, expectedFields)
+	expected := []any{"ctxerr_test.TestNoCtx", "ctxerr_test.TestNoCtx"}
+	if l := ctxerr.AllFields(err)[ctxerr.FieldKeyLocation]; !reflect.DeepEqual(l, expected) {
+		t.Error("location did not match", l, expected)
 	}
 
-	if !ctxerr.HasField(err, "a") {
-		t.Error("missing in HasField")
+	if ctxerr.WrapNoCtx(nil, "WRAP") != nil {
+		t.Error("nil should not be wrapped")
 	}
+}
 
-	if !ctxerr.HasCategory(err, "category2") {
-		t.Error("missing category2")
+func TestSortSliceFields(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.FieldsAsSlice = append(in.FieldsAsSlice, "step")
+
+	branch := func(location, step string) error {
+		ctx := in.SetField(context.Background(), ctxerr.FieldKeyLocation, location)
+		return in.New(in.SetField(ctx, "step", step), "CODE")
 	}
-	if !ctxerr.HasCategory(err, "category1") {
-		t.Error("missing category1")
+	err := in.Join(context.Background(), "JOIN", branch("c.go", "3"), branch("a.go", "1"), branch("b.go", "2"))
+
+	if l := in.AllFields(err)["step"]; !reflect.DeepEqual(l, []any{"3", "1", "2"}) {
+		t.Error("unsorted order should follow the branches", l)
 	}
-}
 
-type OtherFieldFuncError struct {
-	fields map[string]any
-	err    error
+	in.SortSliceFields = true
+	f := in.AllFields(err)
+	if l := f["step"]; !reflect.DeepEqual(l, []any{"1", "2", "3"}) {
+		t.Error("steps should be sorted", l)
+	}
+	locations, _ := f[ctxerr.FieldKeyLocation].([]any)
+	if len(locations) < 2 || !slices.IsSortedFunc(locations, func(a, b any) int { return strings.Compare(a.(string), b.(string)) }) {
+		t.Error("locations should be sorted", locations)
+	}
 }
 
-func (offe OtherFieldFuncError) Error() string {
-	return offe.err.Error()
-}
+func TestEnsure(t *testing.T) {
+	if ctxerr.Ensure(context.Background(), nil) != nil {
+		t.Error("nil should stay nil")
+	}
 
-func (offe OtherFieldFuncError) FieldsMap() map[string]any {
-	return offe.fields
-}
+	existing := ctxerr.New(context.Background(), "CODE", "msg")
+	if ce := ctxerr.Ensure(context.Background(), existing); ce != existing {
+		t.Error("a CtxErr should be returned as is")
+	}
 
-func (offe OtherFieldFuncError) Unwrap() error {
-	return errors.Unwrap(offe.err)
-}
+	plain := errors.New("plain")
+	ce := ctxerr.Ensure(ctxerr.SetField(context.Background(), "a", "a"), plain)
+	if ce == nil || ce.Unwrap() != plain {
+		t.Fatal("a plain error should be wrapped", ce)
+	}
+	if ce.Error() != "plain" {
+		t.Error("message did not match", ce)
+	}
+	if ce.Fields()["a"] != "a" {
+		t.Error("fields did not match", ce.Fields())
+	}
+	if _, ok := ctxerr.GetCode(ce); ok {
+		t.Error("the wrapper should not have a code")
+	}
 
-type IFieldsMap interface {
-	FieldsMap() map[string]any
-}
+	fmtWrapped := fmt.Errorf("outer: %w", existing)
+	if ce := ctxerr.Ensure(context.Background(), fmtWrapped); ce.Unwrap() != fmtWrapped {
+		t.Error("an error wrapping a CtxErr should be wrapped so the outer message is kept", ce)
+	}
 
-func NewOtherFieldFuncError(msg string, fields map[string]any) error {
-	return OtherFieldFuncError{
-		fields: fields,
-		err:    fmt.Errorf(msg),
+	in := ctxerr.NewInstance()
+	in.AddCreateHook(func(ctx context.Context, code string, wrapping error) context.Context {
+		return in.SetField(ctx, "hooked", true)
+	})
+	if ce := in.Ensure(context.Background(), plain); !in.HasField(ce, "hooked") {
+		t.Error("the create hooks should run like Wrap", in.AllFields(ce))
+	}
+	in.MaxWrapDepth = 1
+	if ce := in.Ensure(context.Background(), plain); ce == nil || ce.Unwrap() != plain || in.HasField(ce, "hooked") {
+		t.Error("reaching MaxWrapDepth should still wrap but without the create hooks", ce)
 	}
 }
 
-func TestAddFieldsFuncs(t *testing.T) {
+func TestSplitJoinedOnHandle(t *testing.T) {
 	in := ctxerr.NewInstance()
-	in.AddFieldsFunc(func(err error) map[string]any {
-		if v, ok := err.(IFieldsMap); ok {
-			return v.FieldsMap()
-		}
-		return nil
+	var codes []string
+	in.AddHandleHook(func(err error) {
+		code, _ := in.GetCode(err)
+		codes = append(codes, code)
 	})
+	var ctxHandled int
+	in.AddHandleHookCtx(func(context.Context, error) { ctxHandled++ })
 
-	err := NewOtherFieldFuncError("msg", map[string]any{"a": "a"})
-	if in.AllFields(err)["a"] != "a" {
-		t.Error("field not set from interface")
-	}
+	joined := errors.Join(
+		in.New(context.Background(), "NETWORK"),
+		in.New(context.Background(), "VALIDATION"),
+		errors.New("plain"),
+	)
 
-	err = ctxerr.New(ctxerr.SetField(context.Background(), "b", "b"), "CODE", "msg")
-	if in.AllFields(err)["b"] != "b" {
-		t.Error("field not set from default interface")
+	in.Handle(joined)
+	if len(codes) != 1 || ctxHandled != 1 {
+		t.Error("joined errors should be handled once by default", codes, ctxHandled)
 	}
 
-	// Ensure empty funcs defaults
-	in.GetFieldsFuncs = nil
-	ctx := ctxerr.SetField(context.Background(), "c", "c")
-	ctx = ctxerr.SetCategory(ctx, "foo")
-	err = ctxerr.New(ctx, "CODE", "msg")
-	if in.AllFields(err)["c"] != "c" {
-		t.Error("field not set from default interface fallback")
+	codes, ctxHandled = nil, 0
+	in.SplitJoinedOnHandle = true
+	if !in.Handle(joined) {
+		t.Error("hooks should run")
 	}
-	if !in.HasCategory(err, "foo") {
-		t.Error("missing category")
+	if !reflect.DeepEqual(codes, []string{"NETWORK", "VALIDATION", ""}) || ctxHandled != 3 {
+		t.Error("each branch should be handled", codes, ctxHandled)
 	}
-	if !in.HasField(err, "c") {
-		t.Error("missing hasField")
+
+	codes, ctxHandled = nil, 0
+	in.Handle(in.New(context.Background(), "SINGLE"))
+	if len(codes) != 1 || ctxHandled != 1 {
+		t.Error("errors that are not joined should be handled once", codes, ctxHandled)
 	}
 }
 
-func TestGlobaTestAddFieldsFuncs(t *testing.T) {
-	ctxerr.AddFieldsFunc(func(_ error) map[string]any { return nil })
-}
+func TestContainsErr(t *testing.T) {
+	ctx := context.Background()
+	lookup := ctxerr.Wrap(ctx, sql.ErrNoRows, "LOOKUP", "lookup")
+	joined := ctxerr.Join(ctx, "JOIN", ctxerr.New(ctx, "VALIDATION"), lookup)
+	err := fmt.Errorf("request: %w", ctxerr.Wrap(ctx, joined, "REQUEST"))
 
-func TestJoined(t *testing.T) {
-	actx := ctxerr.SetField(context.Background(), "a", "a")
-	actx = ctxerr.SetCategory(actx, "cat_a")
-	a := ctxerr.New(actx, "CODE_A", "msg_a")
+	tests := []struct {
+		name     string
+		err      error
+		target   error
+		expected bool
+	}{
+		{name: "joined branch", err: err, target: sql.ErrNoRows, expected: true},
+		{name: "missing", err: err, target: io.EOF},
+		{name: "itself", err: sql.ErrNoRows, target: sql.ErrNoRows, expected: true},
+		{name: "nil", target: sql.ErrNoRows},
+	}
 
-	bctx := ctxerr.SetField(context.Background(), "b", "b")
-	bctx = ctxerr.SetCategory(bctx, "cat_b")
-	b := ctxerr.New(bctx, "CODE_B", "msg_b")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := ctxerr.ContainsErr(tt.err, tt.target); v != tt.expected {
+				t.Error("contains did not match", v, tt.expected)
+			}
+		})
+	}
+}
 
-	cctx := ctxerr.SetField(context.Background(), "c", "c")
-	c := ctxerr.Wrap(cctx, errors.Join(a, b), "CODE_C", "msg_c")
+func TestOffloadLargeFields(t *testing.T) {
+	store := map[string]any{}
+	fail := false
+	hook := ctxerr.OffloadLargeFields(10, func(v any) (string, error) {
+		if fail {
+			return "", errors.New("store failed")
+		}
+		id := fmt.Sprintf("blob://%d", len(store))
+		store[id] = v
+		return id, nil
+	})
 
-	if !ctxerr.HasCategory(c, "cat_a") {
-		t.Error("missing category cat_a")
+	in := ctxerr.NewInstance()
+	in.AddFieldHook(hook)
+
+	big := strings.Repeat("a", 11)
+	ctx := in.SetFields(context.Background(), map[string]any{
+		"small":  "small",
+		"big":    big,
+		"bytes":  []byte(big),
+		"struct": map[string]any{"key": "value"},
+	})
+
+	f := ctxerr.Fields(ctx)
+	if f["small"] != "small" {
+		t.Error("small values should be kept", f["small"])
+	}
+	for _, k := range []string{"big", "bytes", "struct"} {
+		ref, _ := f[k].(string)
+		if !strings.HasPrefix(ref, "blob://") {
+			t.Error("large values should be offloaded", k, f[k])
+			continue
+		}
+		if !reflect.DeepEqual(store[ref], map[string]any{"big": big, "bytes": []byte(big), "struct": map[string]any{"key": "value"}}[k]) {
+			t.Error("stored value did not match", k, store[ref])
+		}
 	}
-	if !ctxerr.HasCategory(c, "cat_b") {
-		t.Error("missing category cat_b")
+
+	fail = true
+	if v := hook(context.Background(), big); v != big {
+		t.Error("the value should be kept when the store fails", v)
 	}
+}
 
-	if !ctxerr.HasField(c, "a") {
-		t.Error("missing field a")
+func TestGetActionAndStatusCode(t *testing.T) {
+	ctx := context.Background()
+	inner := ctxerr.NewHTTP(ctx, "CODE", "inner action", http.StatusBadRequest, "msg")
+
+	tests := []struct {
+		name               string
+		err                error
+		expectedAction     string
+		expectedHasAction  bool
+		expectedStatusCode int
+		expectedHasStatus  bool
+	}{
+		{name: "nil"},
+		{name: "absent", err: ctxerr.New(ctx, "CODE")},
+		{
+			name:               "present",
+			err:                inner,
+			expectedAction:     "inner action",
+			expectedHasAction:  true,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedHasStatus:  true,
+		},
+		{
+			name:               "outermost",
+			err:                ctxerr.WrapHTTP(ctx, inner, "WRAP", "outer action", http.StatusConflict),
+			expectedAction:     "outer action",
+			expectedHasAction:  true,
+			expectedStatusCode: http.StatusConflict,
+			expectedHasStatus:  true,
+		},
+		{
+			name:              "structured action",
+			err:               ctxerr.New(ctxerr.SetActionValue(ctx, map[string]any{"key": "retry"}), "CODE"),
+			expectedAction:    "map[key:retry]",
+			expectedHasAction: true,
+		},
+		{
+			name:               "string status code",
+			err:                ctxerr.New(ctxerr.SetField(ctx, ctxerr.FieldKeyStatusCode, "404"), "CODE"),
+			expectedStatusCode: http.StatusNotFound,
+			expectedHasStatus:  true,
+		},
+		{
+			name: "invalid status code",
+			err:  ctxerr.New(ctxerr.SetField(ctx, ctxerr.FieldKeyStatusCode, "not found"), "CODE"),
+		},
 	}
-	if !ctxerr.HasField(c, "b") {
-		t.Error("missing field b")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if a, ok := ctxerr.GetAction(tt.err); a != tt.expectedAction || ok != tt.expectedHasAction {
+				t.Error("action did not match", a, ok)
+			}
+			if sc, ok := ctxerr.GetStatusCode(tt.err); sc != tt.expectedStatusCode || ok != tt.expectedHasStatus {
+				t.Error("status code did not match", sc, ok)
+			}
+		})
 	}
+}
 
-	f := ctxerr.AllFields(c)
-	expectedFields := map[string]any{
-		"a":              "a",
-		"b":              "b",
-		"c":              "c",
-		"error_code":     "CODE_B",
-		"error_category": "cat_b",
-		"error_location": []any{
-			"ctxerr_test.TestJoined",
-			"ctxerr_test.TestJoined",
-			"ctxerr_test.TestJoined",
-		},
+func TestCodePrecedence(t *testing.T) {
+	ctx := context.Background()
+	err := ctxerr.Wrap(ctx, ctxerr.New(ctx, "INNER", "inner"), "OUTER", "outer")
+	noOuterCode := ctxerr.Wrap(ctx, ctxerr.New(ctx, "INNER", "inner"), "", "outer")
+
+	tests := []struct {
+		name       string
+		precedence ctxerr.CodePrecedence
+		err        error
+		expected   any
+	}{
+		{name: "default", err: err, expected: "INNER"},
+		{name: "innermost", precedence: ctxerr.CodePrecedenceInnermost, err: err, expected: "INNER"},
+		{name: "outermost", precedence: ctxerr.CodePrecedenceOutermost, err: err, expected: "OUTER"},
+		{name: "outermost without outer code", precedence: ctxerr.CodePrecedenceOutermost, err: noOuterCode, expected: "INNER"},
 	}
 
-	if !reflect.DeepEqual(f, expectedFields) {
-		t.Errorf("fields didn't match \n%#v\n%#v", f, expectedFields)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := ctxerr.NewInstance()
+			in.CodePrecedence = tt.precedence
+			if code := in.AllFields(tt.err)[ctxerr.FieldKeyCode]; code != tt.expected {
+				t.Error("code did not match", code, tt.expected)
+			}
+			if info, _ := in.Inspect(tt.err); info.Code != tt.expected {
+				t.Error("inspect code did not match", info.Code, tt.expected)
+			}
+		})
 	}
 }
@@ -0,0 +1,10 @@
+module github.com/mvndaai/ctxerr/grpc
+
+go 1.23
+
+require (
+	github.com/mvndaai/ctxerr v0.0.0-20261015073729-0ee29c0140ef
+	google.golang.org/grpc v1.64.0
+)
+
+require golang.org/x/sys v0.18.0 // indirect
@@ -0,0 +1,48 @@
+/*
+Package grpc adds gRPC status codes to ctxerr errors.
+
+	ctxerrgrpc.NewGRPC(ctx, "<code>", "<action>", codes.NotFound, "<message>")
+	ctxerrgrpc.WrapGRPC(ctx, err, "<code>", "<action>", codes.InvalidArgument, "<message>")
+*/
+package grpc
+
+import (
+	"context"
+
+	"github.com/mvndaai/ctxerr"
+	"google.golang.org/grpc/codes"
+)
+
+// FieldKeyGRPCCode can be used to choose a status code to return to a gRPC request
+const FieldKeyGRPCCode = "error_grpc_code"
+
+// SetGRPCCode is equivelent to ctxerr.SetField(ctx, FieldKeyGRPCCode, code)
+func SetGRPCCode(ctx context.Context, code codes.Code) context.Context {
+	return ctxerr.SetField(ctx, FieldKeyGRPCCode, code)
+}
+
+// NewGRPC creates a new error with action and gRPC code
+func NewGRPC(ctx context.Context, code, action string, grpcCode codes.Code, message ...any) error {
+	if action != "" {
+		ctx = ctxerr.SetAction(ctx, action)
+	}
+	if grpcCode != codes.OK {
+		ctx = SetGRPCCode(ctx, grpcCode)
+	}
+	return ctxerr.New(ctx, code, message...)
+}
+
+// WrapGRPC creates a new error with action and gRPC code and another wrapped under it
+// A gRPC code already on the wrapped error takes precedence in ctxerr.AllFields
+func WrapGRPC(ctx context.Context, err error, code, action string, grpcCode codes.Code, message ...any) error {
+	if err == nil {
+		return nil
+	}
+	if action != "" {
+		ctx = ctxerr.SetAction(ctx, action)
+	}
+	if grpcCode != codes.OK {
+		ctx = SetGRPCCode(ctx, grpcCode)
+	}
+	return ctxerr.Wrap(ctx, err, code, message...)
+}
@@ -0,0 +1,98 @@
+package grpc_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mvndaai/ctxerr"
+	ctxerrgrpc "github.com/mvndaai/ctxerr/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCFuncs(t *testing.T) {
+	tests := []struct {
+		name             string
+		err              error
+		expectedCode     any
+		expectedAction   any
+		expectedGRPCCode any
+		expectedMessage  string
+	}{
+		{
+			name:             "NewGRPC",
+			err:              ctxerrgrpc.NewGRPC(context.Background(), "c", "a", codes.NotFound, "m", 1, "v"),
+			expectedCode:     "c",
+			expectedAction:   "a",
+			expectedGRPCCode: codes.NotFound,
+			expectedMessage:  "m1v",
+		},
+		{
+			name:             "NewGRPC OK code",
+			err:              ctxerrgrpc.NewGRPC(context.Background(), "c", "", codes.OK, "m"),
+			expectedCode:     "c",
+			expectedAction:   nil,
+			expectedGRPCCode: nil,
+			expectedMessage:  "m",
+		},
+		{
+			name:             "WrapGRPC",
+			err:              ctxerrgrpc.WrapGRPC(context.Background(), fmt.Errorf("e"), "c", "a", codes.InvalidArgument, "m"),
+			expectedCode:     "c",
+			expectedAction:   "a",
+			expectedGRPCCode: codes.InvalidArgument,
+			expectedMessage:  "m : e",
+		},
+		{
+			name:             "WrapGRPC nil error",
+			err:              ctxerrgrpc.WrapGRPC(context.Background(), nil, "c", "a", codes.InvalidArgument, "m"),
+			expectedCode:     nil,
+			expectedAction:   nil,
+			expectedGRPCCode: nil,
+		},
+		{
+			name: "Wrapped error already has gRPC code",
+			err: func() error {
+				err := ctxerrgrpc.NewGRPC(context.Background(), "ci", "ai", codes.NotFound, "mi")
+				return ctxerrgrpc.WrapGRPC(context.Background(), err, "co", "ao", codes.Internal, "mo")
+			}(),
+			expectedCode:     "ci",
+			expectedAction:   "ai",
+			expectedGRPCCode: codes.NotFound,
+			expectedMessage:  "mo : mi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ctxerr.AllFields(tt.err)
+
+			if v := f[ctxerr.FieldKeyCode]; v != tt.expectedCode {
+				t.Errorf("code did not match: %v", v)
+			}
+			if v := f[ctxerr.FieldKeyAction]; v != tt.expectedAction {
+				t.Errorf("action did not match: %v - %v", v, tt.expectedAction)
+			}
+			if v := f[ctxerrgrpc.FieldKeyGRPCCode]; v != tt.expectedGRPCCode {
+				t.Errorf("grpc code did not match: %v - %v", v, tt.expectedGRPCCode)
+			}
+			if tt.err != nil {
+				if v := tt.err.Error(); v != tt.expectedMessage {
+					t.Errorf("message did not match: %v - %v", v, tt.expectedMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	err := ctxerrgrpc.NewGRPC(context.Background(), "c", "", codes.NotFound)
+	err = ctxerrgrpc.WrapGRPC(context.Background(), err, "c", "", codes.NotFound)
+
+	locs := ctxerr.AllFields(err)[ctxerr.FieldKeyLocation].([]any)
+	for _, loc := range locs {
+		if loc != "grpc_test.TestLocation" {
+			t.Error("location should be the caller of the helper", loc)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Package fiber handles errors for gofiber/fiber v2 apps.
+
+Set ErrorHandler on the app config so returned errors are handled and respond with the standard JSON response.
+
+	app := fiber.New(fiber.Config{ErrorHandler: ctxerrfiber.ErrorHandler(showMessage, showFields)})
+*/
+package fiber
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+)
+
+// ErrorHandler handles the error and responds with the status code and response from ctxerrhttp.StatusCodeAndResponse
+// Errors from fiber like routing errors use the status code of the *fiber.Error
+func ErrorHandler(showMessage, showFields bool) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		ctx := c.UserContext()
+
+		var fe *fiber.Error
+		if errors.As(err, &fe) && !ctxerr.HasField(err, ctxerr.FieldKeyStatusCode) {
+			err = ctxerr.WrapHTTP(ctx, err, "ctxerr_fiber", "", fe.Code, nil)
+		}
+		_, err = ctxerrhttp.EnsureErrorTraceID(ctx, err)
+		ctxerr.Handle(err)
+
+		statusCode, response := ctxerrhttp.StatusCodeAndResponse(err, showMessage, showFields)
+		if v, ok := ctxerrhttp.RetryAfterHeader(err); ok {
+			c.Set(fiber.HeaderRetryAfter, v)
+		}
+		return c.Status(statusCode).JSON(response)
+	}
+}
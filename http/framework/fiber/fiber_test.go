@@ -0,0 +1,187 @@
+package fiber_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+	ctxerrfiber "github.com/mvndaai/ctxerr/http/framework/fiber"
+)
+
+func TestErrorHandler(t *testing.T) {
+	log.SetOutput(&strings.Builder{})
+
+	var handled error
+	ctxerr.AddHandleHook(func(err error) { handled = err })
+
+	tests := []struct {
+		name    string
+		path    string
+		handler fiber.Handler
+
+		expectedStatusCode int
+		expectedCode       string
+		expectedMessage    string
+		expectedTraceID    string
+	}{
+		{
+			name:               "ok",
+			handler:            func(c *fiber.Ctx) error { return c.SendStatus(http.StatusNoContent) },
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name: "handler error",
+			handler: func(c *fiber.Ctx) error {
+				return ctxerr.NewHTTP(c.UserContext(), "code", "", http.StatusBadRequest, "bad")
+			},
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       "code",
+			expectedMessage:    "bad",
+		},
+		{
+			name:               "plain error",
+			handler:            func(c *fiber.Ctx) error { return errors.New("plain") },
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedMessage:    "plain",
+		},
+		{
+			name:               "fiber error",
+			handler:            func(c *fiber.Ctx) error { return fiber.ErrConflict },
+			expectedStatusCode: http.StatusConflict,
+			expectedCode:       "ctxerr_fiber",
+			expectedMessage:    "Conflict",
+		},
+		{
+			name:               "route not found",
+			path:               "/missing",
+			handler:            func(c *fiber.Ctx) error { return nil },
+			expectedStatusCode: http.StatusNotFound,
+			expectedCode:       "ctxerr_fiber",
+			expectedMessage:    "Cannot GET /missing",
+		},
+		{
+			name: "trace ID from user context",
+			handler: func(c *fiber.Ctx) error {
+				c.SetUserContext(ctxerr.SetField(context.Background(), ctxerrhttp.FieldKeyTraceID, "traceID"))
+				return errors.New("plain")
+			},
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedMessage:    "plain",
+			expectedTraceID:    "traceID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			app := fiber.New(fiber.Config{ErrorHandler: ctxerrfiber.ErrorHandler(true, true)})
+			app.Get("/", tt.handler)
+
+			path := tt.path
+			if path == "" {
+				path = "/"
+			}
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.expectedStatusCode {
+				t.Error("Status code did not match", resp.StatusCode, tt.expectedStatusCode)
+			}
+			if tt.expectedMessage == "" {
+				if handled != nil {
+					t.Error("error should not have been handled", handled)
+				}
+				return
+			}
+
+			if ct := resp.Header.Get("Content-Type"); ct != fiber.MIMEApplicationJSON {
+				t.Error("Content type did not match", ct)
+			}
+			var r ctxerrhttp.ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+				t.Fatal("could not decode body", err)
+			}
+			if r.Error.Code != tt.expectedCode {
+				t.Error("Code did not match", r.Error.Code, tt.expectedCode)
+			}
+			if r.Error.Message != tt.expectedMessage {
+				t.Error("Message did not match", r.Error.Message, tt.expectedMessage)
+			}
+			if tt.expectedTraceID != "" && r.Error.TraceID != tt.expectedTraceID {
+				t.Error("TraceID did not match", r.Error.TraceID, tt.expectedTraceID)
+			}
+			if handled == nil {
+				t.Error("error was not handled")
+			}
+			if v := ctxerr.AllFields(handled)[ctxerrhttp.FieldKeyTraceID]; v != r.Error.TraceID {
+				t.Error("logged TraceID did not match", v, r.Error.TraceID)
+			}
+		})
+	}
+}
+
+type traceKey struct{}
+
+func TestErrorHandlerTraceID(t *testing.T) {
+	log.SetOutput(&strings.Builder{})
+	defer func(f func(context.Context) string) { ctxerrhttp.TraceID = f }(ctxerrhttp.TraceID)
+	ctxerrhttp.TraceID = func(ctx context.Context) string {
+		id, _ := ctx.Value(traceKey{}).(string)
+		return id
+	}
+
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	var handled error
+	ctxerr.AddHandleHook(func(err error) { handled = err })
+
+	app := fiber.New(fiber.Config{ErrorHandler: ctxerrfiber.ErrorHandler(false, false)})
+	app.Get("/", func(c *fiber.Ctx) error {
+		c.SetUserContext(context.WithValue(context.Background(), traceKey{}, "span"))
+		return errors.New("plain")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r ctxerrhttp.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal("could not decode body", err)
+	}
+	if r.Error.TraceID != "span" {
+		t.Error("TraceID should come from the user context", r.Error.TraceID)
+	}
+	if v := ctxerr.AllFields(handled)[ctxerrhttp.FieldKeyTraceID]; v != "span" {
+		t.Error("logged TraceID did not match", v)
+	}
+}
+
+func TestErrorHandlerRetryAfter(t *testing.T) {
+	log.SetOutput(&strings.Builder{})
+
+	app := fiber.New(fiber.Config{ErrorHandler: ctxerrfiber.ErrorHandler(false, false)})
+	app.Get("/", func(c *fiber.Ctx) error {
+		ctx := ctxerr.SetRetryAfter(c.UserContext(), 1500*time.Millisecond)
+		return ctxerr.NewHTTP(ctx, "code", "", http.StatusServiceUnavailable, "busy")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := resp.Header.Get(fiber.HeaderRetryAfter); v != "2" {
+		t.Error("Retry-After did not match", v)
+	}
+}
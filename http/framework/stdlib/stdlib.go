@@ -0,0 +1,66 @@
+/*
+Package stdlib handles errors and panics for net/http handlers.
+
+Wrap a handler with Middleware to turn panics into ctxerr errors and return the standard JSON response.
+
+	http.Handle("/", stdlib.Middleware(mux, showMessage, showFields))
+
+Wrap it with TraceID to add a generated trace ID to every request that does not have one.
+
+	http.Handle("/", stdlib.TraceID(stdlib.Middleware(mux, showMessage, showFields)))
+
+Handlers that return an error can be adapted with Handler.
+
+	http.Handle("/foo", stdlib.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return ctxerr.NewHTTP(r.Context(), "<code>", "<action>", http.StatusBadRequest, "<message>")
+	}, showMessage, showFields))
+*/
+package stdlib
+
+import (
+	"net/http"
+
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+)
+
+// CategoryPanic is the category set on errors created from a recovered panic
+const CategoryPanic = ctxerr.CategoryPanic
+
+// HandlerFunc is an http.HandlerFunc that can return an error
+type HandlerFunc func(http.ResponseWriter, *http.Request) error
+
+// Middleware recovers panics in the next handler and responds with the error
+func Middleware(next http.Handler, showMessage, showFields bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			// Let net/http handle aborts like it normally would
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			ctxerrhttp.WriteResponse(w, ctxerr.Recover(r.Context(), rec, "ctxerr_panic"), showMessage, showFields)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TraceID adds a trace ID to the request context if it does not have one using ctxerrhttp.EnsureTraceID
+func TraceID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, _ := ctxerrhttp.EnsureTraceID(r.Context())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Handler adapts a HandlerFunc to an http.Handler that responds with the returned error
+func Handler(fn HandlerFunc, showMessage, showFields bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(w, r); err != nil {
+			ctxerrhttp.WriteResponse(w, err, showMessage, showFields)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package stdlib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+	"github.com/mvndaai/ctxerr/http/framework/stdlib"
+)
+
+func TestMiddleware(t *testing.T) {
+	log.SetOutput(&strings.Builder{})
+
+	var handled error
+	ctxerr.AddHandleHook(func(err error) { handled = err })
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+
+		expectedStatusCode int
+		expectedCode       string
+		expectedMessage    string
+		expectedPanic      bool
+	}{
+		{
+			name:               "ok",
+			handler:            http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }),
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "panic string",
+			handler:            http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") }),
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedCode:       "ctxerr_panic",
+			expectedMessage:    "panic: boom",
+			expectedPanic:      true,
+		},
+		{
+			name: "panic error",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic(ctxerr.NewHTTP(r.Context(), "code", "", http.StatusConflict, "boom"))
+			}),
+			expectedStatusCode: http.StatusConflict,
+			expectedCode:       "code",
+			expectedMessage:    "panic : boom",
+			expectedPanic:      true,
+		},
+		{
+			name: "handler error",
+			handler: stdlib.Handler(func(w http.ResponseWriter, r *http.Request) error {
+				return ctxerr.NewHTTP(r.Context(), "code", "", http.StatusBadRequest, "bad")
+			}, true, true),
+			expectedStatusCode: http.StatusBadRequest,
+			expectedCode:       "code",
+			expectedMessage:    "bad",
+		},
+		{
+			name: "handler no error",
+			handler: stdlib.Handler(func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusAccepted)
+				return nil
+			}, true, true),
+			expectedStatusCode: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled = nil
+			rec := httptest.NewRecorder()
+			stdlib.Middleware(tt.handler, true, true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.expectedStatusCode {
+				t.Error("Status code did not match", rec.Code, tt.expectedStatusCode)
+			}
+			if tt.expectedCode == "" {
+				if handled != nil {
+					t.Error("error should not have been handled", handled)
+				}
+				return
+			}
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Error("Content type did not match", ct)
+			}
+			var r ctxerrhttp.ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+				t.Fatal("could not decode body", err)
+			}
+			if r.Error.Code != tt.expectedCode {
+				t.Error("Code did not match", r.Error.Code, tt.expectedCode)
+			}
+			if r.Error.Message != tt.expectedMessage {
+				t.Error("Message did not match", r.Error.Message, tt.expectedMessage)
+			}
+
+			if handled == nil {
+				t.Fatal("error was not handled")
+			}
+			if ctxerr.HasCategory(handled, stdlib.CategoryPanic) != tt.expectedPanic {
+				t.Error("panic category did not match")
+			}
+			if ctxerr.HasField(handled, ctxerr.FieldKeyStack) != tt.expectedPanic {
+				t.Error("panic stack did not match")
+			}
+		})
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	defer func() {
+		if r := recover(); !errors.Is(r.(error), http.ErrAbortHandler) {
+			t.Error("expected abort to be repanicked", r)
+		}
+	}()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic(http.ErrAbortHandler) })
+	stdlib.Middleware(h, true, true).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestTraceID(t *testing.T) {
+	h := stdlib.TraceID(stdlib.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return ctxerr.New(r.Context(), "code")
+	}, false, false))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var r ctxerrhttp.ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatal("could not decode body", err)
+	}
+	if r.Error.TraceID == "" {
+		t.Error("trace ID should be generated")
+	}
+}
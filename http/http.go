@@ -2,29 +2,48 @@
 Package http is used to generate common HTTP responses.
 
 Use StatusCodeAndResponse(...) in HTTP handlers to return a common JSON response.
+WriteResponse(...) handles the error and writes that response.
 
 	{
 		"error": {
 			"code" : "<code passed to ctxerr.New/Wrap>",
 			"action" : "<value under the field key ctxerr.FieldKeyAction>",
-			"messsage" : "error.Error()",
+			"actionData" : "<value under ctxerr.FieldKeyAction when it is not a string>",
+			"messsage" : "error.Error() or the public message, the key is MessageKey",
 			"traceID" : "<trace ID, if configured>",
 			"fields" : {},
+			"errors" : [{"field": "<value under ctxerrhttp.FieldKeyValidationField>", "code": "", "message": ""}],
 		}
 	}
+
+A public message set with ctxerr.SetPublicMessage is always returned instead of error.Error().
+
+Validation errors are collected from every error in the tree with the field FieldKeyValidationField.
+Use errors.Join to return multiple validation errors at once.
 */
 package http
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"reflect"
 	"strconv"
 
 	"github.com/mvndaai/ctxerr"
+	"github.com/mvndaai/ctxerr/joinederr"
 )
 
 const FieldKeyTraceID = "traceID"
 
+// FieldKeyValidationField is the name of the request field that failed validation
+const FieldKeyValidationField = "error_validation_field"
+
 type (
 	// ErrorResponse is the default HTTP response
 	ErrorResponse struct {
@@ -33,17 +52,180 @@ type (
 
 	// Details of a response
 	Details struct {
-		TraceID string         `json:"traceID,omitempty"`
-		Code    string         `json:"code"`
-		Action  string         `json:"action,omitempty"`
-		Message string         `json:"messsage,omitempty"`
-		Fields  map[string]any `json:"fields,omitempty"`
+		TraceID    string         `json:"traceID,omitempty"`
+		Code       string         `json:"code"`
+		Action     string         `json:"action,omitempty"`
+		ActionData any            `json:"actionData,omitempty"` // structured action set with ctxerr.SetActionValue
+		Message    string         `json:"messsage,omitempty"`   // the key is MessageKey
+		Fields     map[string]any `json:"fields,omitempty"`
+		Errors     []FieldError   `json:"errors,omitempty"`
+		Extra      map[string]any `json:"extra,omitempty"` // added with ResponseBuilder
+	}
+
+	// FieldError is a validation error for a single field of a request
+	FieldError struct {
+		Field   string `json:"field"`
+		Code    string `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
 	}
 )
 
+// MessageKey is the JSON key of Details.Message, set it to "message" to use the correct spelling
+// It defaults to the misspelled "messsage" so existing clients keep working, both are accepted when unmarshalling
+var MessageKey = legacyMessageKey
+
+const legacyMessageKey = "messsage"
+
+// MarshalJSON fulfills the json.Marshaler interface to use MessageKey for the message
+func (d Details) MarshalJSON() ([]byte, error) {
+	type details Details
+	b, err := json.Marshal(details(d))
+	if err != nil || MessageKey == legacyMessageKey {
+		return b, err
+	}
+
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if msg, ok := m[legacyMessageKey]; ok {
+		delete(m, legacyMessageKey)
+		m[MessageKey] = msg
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON fulfills the json.Unmarshaler interface to accept the message under MessageKey, "message", or "messsage"
+func (d *Details) UnmarshalJSON(b []byte) error {
+	type details Details
+	if err := json.Unmarshal(b, (*details)(d)); err != nil {
+		return err
+	}
+
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for _, k := range []string{MessageKey, "message"} {
+		if msg, ok := m[k]; ok {
+			return json.Unmarshal(msg, &d.Message)
+		}
+	}
+	return nil
+}
+
+// CategoryStatusMap is the status code used for an error with the category and no explicit status code
+// Categories can be a ctxerr.Category or the same string
+var CategoryStatusMap = map[any]int{
+	ctxerr.CategoryNotFound:         http.StatusNotFound,
+	ctxerr.CategoryUnauthorized:     http.StatusUnauthorized,
+	ctxerr.CategoryConflict:         http.StatusConflict,
+	ctxerr.CategoryInternal:         http.StatusInternalServerError,
+	ctxerr.CategoryDeadlineExceeded: http.StatusGatewayTimeout,
+}
+
+// DefaultHiddenFields are internal fields removed from the response when ResponseOptions.HiddenFields is nil
+var DefaultHiddenFields = []string{ctxerr.FieldKeyLocation, ctxerr.FieldKeyStack, ctxerr.FieldKeyHandled, ctxerr.FieldKeyCause}
+
+// ResponseOptions configures what is included in the response
+type ResponseOptions struct {
+	// ShowMessage adds the error message to the response
+	ShowMessage bool
+	// ShowFields adds the fields of the error to the response
+	ShowFields bool
+	// HiddenFields are never added to the response, nil uses DefaultHiddenFields
+	HiddenFields []string
+	// Redactors are applied to every field value before they are added to the response
+	Redactors []func(key string, value any) any
+	// DefaultStatusCode is used when the error has no status code, 0 uses http.StatusInternalServerError
+	DefaultStatusCode int
+	// Instance is used to read the fields and their keys, nil uses the global instance
+	Instance *ctxerr.Instance
+}
+
 // StatusCodeAndResponse extracts info from the error to create a standard response
-func StatusCodeAndResponse(err error, showMessage, showFields bool) (int, ErrorResponse) {
-	statusCode := 500
+// Optional redactors are applied to every field value before they are added to the response
+func StatusCodeAndResponse(err error, showMessage, showFields bool, redactors ...func(key string, value any) any) (int, ErrorResponse) {
+	return NewResponseBuilder().WithMessage(showMessage).WithFields(showFields, redactors...).Build(err)
+}
+
+// ResponseBuilder composes a response from the standard one with extra details
+type ResponseBuilder struct {
+	opts  ResponseOptions
+	extra map[string]any
+	funcs []func(err error, d *Details)
+}
+
+// NewResponseBuilder creates a builder that hides the message and fields like StatusCodeAndResponse(err, false, false)
+func NewResponseBuilder() *ResponseBuilder {
+	return &ResponseBuilder{}
+}
+
+// WithOptions replaces all the options of the standard response
+func (rb *ResponseBuilder) WithOptions(opts ResponseOptions) *ResponseBuilder {
+	rb.opts = opts
+	return rb
+}
+
+// WithMessage sets if the error message is added to the response
+func (rb *ResponseBuilder) WithMessage(show bool) *ResponseBuilder {
+	rb.opts.ShowMessage = show
+	return rb
+}
+
+// WithFields sets if the fields are added to the response with the redactors applied
+func (rb *ResponseBuilder) WithFields(show bool, redactors ...func(key string, value any) any) *ResponseBuilder {
+	rb.opts.ShowFields = show
+	rb.opts.Redactors = append(rb.opts.Redactors, redactors...)
+	return rb
+}
+
+// WithStatusDefault sets the status code used when the error has no status code or category
+func (rb *ResponseBuilder) WithStatusDefault(statusCode int) *ResponseBuilder {
+	rb.opts.DefaultStatusCode = statusCode
+	return rb
+}
+
+// WithExtra adds values to Details.Extra of every response
+func (rb *ResponseBuilder) WithExtra(extra map[string]any) *ResponseBuilder {
+	if rb.extra == nil {
+		rb.extra = map[string]any{}
+	}
+	maps.Copy(rb.extra, extra)
+	return rb
+}
+
+// WithDetailsFunc adds a function that changes the details after they are built, like adding a docs URL for the code
+func (rb *ResponseBuilder) WithDetailsFunc(f func(err error, d *Details)) *ResponseBuilder {
+	rb.funcs = append(rb.funcs, f)
+	return rb
+}
+
+// Build creates the status code and response for the error
+func (rb *ResponseBuilder) Build(err error) (int, ErrorResponse) {
+	statusCode, r := StatusCodeAndResponseWithOptions(err, rb.opts)
+	if len(rb.extra) > 0 {
+		r.Error.Extra = maps.Clone(rb.extra)
+	}
+	for _, f := range rb.funcs {
+		f(err, &r.Error)
+	}
+	return statusCode, r
+}
+
+// StatusCodeAndResponseWithOptions extracts info from the error to create a standard response
+func StatusCodeAndResponseWithOptions(err error, opts ResponseOptions) (int, ErrorResponse) {
+	showMessage, showFields := opts.ShowMessage, opts.ShowFields
+	in := opts.Instance
+	if in == nil {
+		global := ctxerr.Global()
+		in = &global
+	}
+	allFields, key := in.AllFields, in.FieldKey
+	statusCode := opts.DefaultStatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusInternalServerError
+	}
 	r := ErrorResponse{}
 
 	if showMessage {
@@ -51,46 +233,75 @@ func StatusCodeAndResponse(err error, showMessage, showFields bool) (int, ErrorR
 			r.Error.Message = err.Error()
 		}
 	}
+	if msg, ok := publicMessage(err, key); ok {
+		r.Error.Message = msg
+	}
 
 	if ce, ok := ctxerr.As(err); ok {
 		r.Error.TraceID = TraceID(ce.Context())
 	}
 
-	fields := ctxerr.AllFields(err)
+	r.Error.Errors = validationErrors(err, showMessage, in)
+
+	fields := allFields(err)
+	delete(fields, key(FieldKeyValidationField))
+	delete(fields, key(ctxerr.FieldKeyPublicMessage))
 	if len(fields) > 0 {
-		if code, ok := fields[ctxerr.FieldKeyCode]; ok {
-			r.Error.Code = code.(string)
-			delete(fields, ctxerr.FieldKeyCode)
+		if code, ok := fields[key(ctxerr.FieldKeyCode)]; ok {
+			r.Error.Code = fieldString(code)
+			delete(fields, key(ctxerr.FieldKeyCode))
 		}
-		if action, ok := fields[ctxerr.FieldKeyAction]; ok {
-			r.Error.Action = action.(string)
-			delete(fields, ctxerr.FieldKeyAction)
+		if action, ok := fields[key(ctxerr.FieldKeyAction)]; ok {
+			if v, ok := action.(string); ok {
+				r.Error.Action = v
+			} else {
+				r.Error.ActionData = action
+			}
+			delete(fields, key(ctxerr.FieldKeyAction))
 		}
-		if traceID, ok := fields[FieldKeyTraceID]; ok {
-			r.Error.TraceID = traceID.(string)
-			delete(fields, FieldKeyTraceID)
+		if traceID, ok := fields[key(FieldKeyTraceID)]; ok {
+			r.Error.TraceID = fieldString(traceID)
+			delete(fields, key(FieldKeyTraceID))
 		}
 
-		if sci, ok := fields[ctxerr.FieldKeyStatusCode]; ok {
-			switch v := sci.(type) {
-			case int:
-				statusCode = v
-				delete(fields, ctxerr.FieldKeyStatusCode)
+		if sc, ok := categoryStatusCode(fields[key(ctxerr.FieldKeyCategory)]); ok {
+			statusCode = sc
+		}
+		if sci, ok := fields[key(ctxerr.FieldKeyStatusCode)]; ok {
+			switch rv := reflect.ValueOf(sci); {
+			case rv.CanInt():
+				statusCode = int(rv.Int())
+				delete(fields, key(ctxerr.FieldKeyStatusCode))
+			case rv.CanUint():
+				statusCode = int(rv.Uint())
+				delete(fields, key(ctxerr.FieldKeyStatusCode))
 			default:
-				sc, err := strconv.Atoi(fmt.Sprint(v))
+				sc, err := strconv.Atoi(fmt.Sprint(sci))
 				if err != nil {
-					ctx := ctxerr.SetField(context.Background(), "related_error_code", fields[ctxerr.FieldKeyCode])
-					ctx = ctxerr.SetField(ctx, "status code", v)
-					ctx = ctxerr.SetField(ctx, ctxerr.FieldKeyStatusCode, 418)
-					err = ctxerr.Wrap(ctx, err, "ctxerr_http", "could not convert status code to int")
-					ctxerr.Handle(err)
+					ctx := in.SetField(context.Background(), "related_error_code", fields[key(ctxerr.FieldKeyCode)])
+					ctx = in.SetField(ctx, "status code", sci)
+					ctx = in.SetHTTPStatusCode(ctx, 418)
+					err = in.Wrap(ctx, err, "ctxerr_http", "could not convert status code to int")
+					in.Handle(err)
 					break
 				}
 				statusCode = sc
-				delete(fields, ctxerr.FieldKeyStatusCode)
+				delete(fields, key(ctxerr.FieldKeyStatusCode))
 			}
 		}
 		if showFields {
+			hidden := opts.HiddenFields
+			if hidden == nil {
+				hidden = DefaultHiddenFields
+			}
+			for _, k := range hidden {
+				delete(fields, key(k))
+			}
+			for _, redactor := range opts.Redactors {
+				for k, v := range fields {
+					fields[k] = redactor(k, v)
+				}
+			}
 			r.Error.Fields = fields
 		}
 	}
@@ -98,5 +309,180 @@ func StatusCodeAndResponse(err error, showMessage, showFields bool) (int, ErrorR
 	return statusCode, r
 }
 
+// EnsureTraceID gets the trace ID from the fields on the context or adds a generated one
+// Call it at the start of a request so the trace ID is on every error and log for it
+func EnsureTraceID(ctx context.Context) (context.Context, string) {
+	if v, ok := ctxerr.Fields(ctx)[FieldKeyTraceID]; ok {
+		if id := fieldString(v); id != "" {
+			return ctx, id
+		}
+	}
+	id := newTraceID()
+	return ctxerr.SetField(ctx, FieldKeyTraceID, id), id
+}
+
+// EnsureErrorTraceID wraps the error with a trace ID if it does not have one so the ID in the response is also in the logs
+// The ID comes from TraceID or the fields of the context, or is generated, call it before ctxerr.Handle
+// The error is never changed so it is safe to use with errors shared between requests
+func EnsureErrorTraceID(ctx context.Context, err error) (string, error) {
+	if err == nil {
+		return "", nil
+	}
+	if ce, ok := ctxerr.As(err); ok {
+		if id := TraceID(ce.Context()); id != "" {
+			return id, err
+		}
+	}
+	if v, ok := ctxerr.AllFields(err)[FieldKeyTraceID]; ok {
+		if id := fieldString(v); id != "" {
+			return id, err
+		}
+	}
+
+	id := TraceID(ctx)
+	if v, ok := ctxerr.Fields(ctx)[FieldKeyTraceID]; ok && id == "" {
+		id = fieldString(v)
+	}
+	if id == "" {
+		id = newTraceID()
+	}
+	return id, ctxerr.Wrap(ctxerr.SetField(context.Background(), FieldKeyTraceID, id), err, "", nil)
+}
+
+// WriteResponse handles the error and writes the standard JSON response with its status code
+// A trace ID is added to the error before it is handled if it does not have one
+// A Retry-After header is added when the error has ctxerr.FieldKeyRetryAfter
+func WriteResponse(w http.ResponseWriter, err error, showMessage, showFields bool, redactors ...func(key string, value any) any) {
+	_, err = EnsureErrorTraceID(context.Background(), err)
+	ctxerr.Handle(err)
+
+	statusCode, response := StatusCodeAndResponse(err, showMessage, showFields, redactors...)
+	if v, ok := RetryAfterHeader(err); ok {
+		w.Header().Set("Retry-After", v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(response)
+}
+
+// FromResponse creates an error from the body of a response in the ErrorResponse shape from another service
+// The code, action, trace ID, fields, and validation errors are set on the error with the status code
+// The second error is returned when the body could not be unmarshalled
+func FromResponse(ctx context.Context, statusCode int, body []byte) (error, error) {
+	var r ErrorResponse
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, err
+	}
+	d := r.Error
+
+	ctx = ctxerr.SetFields(ctx, d.Fields)
+	if d.ActionData != nil {
+		ctx = ctxerr.SetActionValue(ctx, d.ActionData)
+	}
+	if d.TraceID != "" {
+		ctx = ctxerr.SetField(ctx, FieldKeyTraceID, d.TraceID)
+	}
+	msg := d.Message
+	if msg == "" {
+		msg = http.StatusText(statusCode)
+	}
+
+	var errs []error
+	for _, fe := range d.Errors {
+		fctx := ctxerr.SetField(context.Background(), FieldKeyValidationField, fe.Field)
+		errs = append(errs, ctxerr.New(fctx, fe.Code, fe.Message))
+	}
+	if len(errs) > 0 {
+		return ctxerr.WrapHTTP(ctx, errors.Join(errs...), d.Code, d.Action, statusCode, msg), nil
+	}
+	return ctxerr.NewHTTP(ctx, d.Code, d.Action, statusCode, msg), nil
+}
+
+// RetryAfterHeader gets the value of a Retry-After header in whole seconds from ctxerr.RetryAfter
+func RetryAfterHeader(err error) (string, bool) {
+	d, ok := ctxerr.RetryAfter(err)
+	if !ok || d < 0 {
+		return "", false
+	}
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10), true
+}
+
+// categoryStatusCode looks up the category in CategoryStatusMap as it is and as a ctxerr.Category or string
+func categoryStatusCode(category any) (int, bool) {
+	if category == nil || !reflect.TypeOf(category).Comparable() {
+		return 0, false
+	}
+	if sc, ok := CategoryStatusMap[category]; ok {
+		return sc, true
+	}
+	switch c := category.(type) {
+	case string:
+		sc, ok := CategoryStatusMap[ctxerr.Category(c)]
+		return sc, ok
+	case ctxerr.Category:
+		sc, ok := CategoryStatusMap[string(c)]
+		return sc, ok
+	}
+	return 0, false
+}
+
+// newTraceID generates a random UUID
+func newTraceID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// fieldString converts a field value that should be a string without panicking on other types
+func fieldString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// publicMessage gets the outermost public message in the tree
+func publicMessage(err error, key func(string) string) (string, bool) {
+	var msg string
+	var found bool
+	joinederr.Walk(err, func(e error) bool {
+		if v, ok := ctxerr.DefaultFieldsFunc(e)[key(ctxerr.FieldKeyPublicMessage)]; ok {
+			msg, found = fmt.Sprint(v), true
+		}
+		return !found
+	})
+	return msg, found
+}
+
+// validationErrors collects an entry for the deepest error with a validation field in each branch of the tree
+func validationErrors(err error, showMessage bool, in *ctxerr.Instance) []FieldError {
+	key := in.FieldKey
+	var fe []FieldError
+	iter := joinederr.NewDepthFirstIterator(err)
+	for {
+		e := iter.Next()
+		if e == nil {
+			return fe
+		}
+
+		fields := ctxerr.DefaultFieldsFunc(e)
+		field, ok := fields[key(FieldKeyValidationField)]
+		if !ok || in.HasField(errors.Unwrap(e), key(FieldKeyValidationField)) {
+			continue
+		}
+
+		v := FieldError{Field: fmt.Sprint(field)}
+		if code, ok := fields[key(ctxerr.FieldKeyCode)].(string); ok {
+			v.Code = code
+		}
+		if showMessage {
+			v.Message = e.Error()
+		}
+		fe = append(fe, v)
+	}
+}
+
 // Deprecated: TraceID is deprecated use FieldKeyTraceID instead
 var TraceID = func(ctx context.Context) string { return "" }
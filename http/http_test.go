@@ -2,14 +2,27 @@ package http_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mvndaai/ctxerr"
 	ctxerrhttp "github.com/mvndaai/ctxerr/http"
 )
 
+// testStatus is a typed status code with a String method so it cannot be parsed from its string
+type testStatus int
+
+func (s testStatus) String() string { return http.StatusText(int(s)) }
+
 func TestStatusCodeAndResponse(t *testing.T) {
 	defaultStatusCode := 500
 	happyCode := "code"
@@ -119,6 +132,26 @@ func TestStatusCodeAndResponse(t *testing.T) {
 			expectedStatusCode: 400,
 			expectedCode:       happyCode,
 		},
+		{
+			name: "status code custom type",
+			err: func() error {
+				ctx := ctxerr.SetField(context.Background(), ctxerr.FieldKeyStatusCode, testStatus(http.StatusTeapot))
+				return ctxerr.New(ctx, happyCode, happyMessage)
+			}(),
+
+			expectedStatusCode: http.StatusTeapot,
+			expectedCode:       happyCode,
+		},
+		{
+			name: "status code uint",
+			err: func() error {
+				ctx := ctxerr.SetField(context.Background(), ctxerr.FieldKeyStatusCode, uint16(404))
+				return ctxerr.New(ctx, happyCode, happyMessage)
+			}(),
+
+			expectedStatusCode: 404,
+			expectedCode:       happyCode,
+		},
 		{
 			name: "status code other",
 			err: func() error {
@@ -184,3 +217,684 @@ func TestStatusCodeAndResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestValidationErrors(t *testing.T) {
+	ctx := context.Background()
+	zip := ctxerr.NewHTTP(ctxerr.SetField(ctx, ctxerrhttp.FieldKeyValidationField, "zip"), "VALIDATION_ZIP", "", 400, "zip must be 5 digits")
+	email := ctxerr.New(ctxerr.SetField(ctx, ctxerrhttp.FieldKeyValidationField, "email"), "VALIDATION_EMAIL", "email is required")
+	err := ctxerr.Wrap(ctx, errors.Join(zip, email), "VALIDATION", "invalid request")
+
+	sc, r := ctxerrhttp.StatusCodeAndResponse(err, true, true)
+	if sc != 400 {
+		t.Error("Status code did not match", sc)
+	}
+
+	expected := []ctxerrhttp.FieldError{
+		{Field: "zip", Code: "VALIDATION_ZIP", Message: "zip must be 5 digits"},
+		{Field: "email", Code: "VALIDATION_EMAIL", Message: "email is required"},
+	}
+	if v, e := fmt.Sprint(r.Error.Errors), fmt.Sprint(expected); v != e {
+		t.Errorf("Errors did not match\n%s\n%s", v, e)
+	}
+	if _, ok := r.Error.Fields[ctxerrhttp.FieldKeyValidationField]; ok {
+		t.Error("validation field should not be in fields")
+	}
+
+	// Wrapping with the same context should not add more entries
+	err = ctxerr.QuickWrap(ctxerr.SetField(ctx, ctxerrhttp.FieldKeyValidationField, "zip"), zip)
+	_, r = ctxerrhttp.StatusCodeAndResponse(err, false, false)
+	expected = []ctxerrhttp.FieldError{{Field: "zip", Code: "VALIDATION_ZIP"}}
+	if v, e := fmt.Sprint(r.Error.Errors), fmt.Sprint(expected); v != e {
+		t.Errorf("Errors did not match\n%s\n%s", v, e)
+	}
+
+	// Non validation errors have none
+	_, r = ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code", "msg"), true, true)
+	if r.Error.Errors != nil {
+		t.Error("expected no validation errors", r.Error.Errors)
+	}
+}
+
+func TestRedactedFields(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "password", "hunter2")
+	ctx = ctxerr.SetField(ctx, "user", "me")
+	err := ctxerr.New(ctx, "code", "msg")
+
+	redactor := func(key string, value any) any {
+		if key == "password" {
+			return ""
+		}
+		return value
+	}
+
+	_, r := ctxerrhttp.StatusCodeAndResponse(err, true, true, redactor)
+	if v := r.Error.Fields["password"]; v != "" {
+		t.Error("password was not redacted in the response", v)
+	}
+	if v := r.Error.Fields["user"]; v != "me" {
+		t.Error("user should not be redacted", v)
+	}
+
+	// The fields used for logs are unchanged
+	if v := ctxerr.AllFields(err)["password"]; v != "hunter2" {
+		t.Error("password should still be in the logs", v)
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	var handled int
+	ctxerr.AddHandleHook(func(error) { handled++ })
+
+	err := ctxerr.NewHTTP(context.Background(), "code", "action", http.StatusConflict, "msg")
+	rec := httptest.NewRecorder()
+	ctxerrhttp.WriteResponse(rec, err, true, false)
+
+	if handled != 1 {
+		t.Error("error should have been handled once", handled)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Error("Status code did not match", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Error("Content type did not match", ct)
+	}
+
+	var r ctxerrhttp.ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+		t.Fatal("could not decode body", err)
+	}
+	if r.Error.TraceID == "" {
+		t.Error("a trace ID should be generated")
+	}
+	expected := ctxerrhttp.Details{TraceID: r.Error.TraceID, Code: "code", Action: "action", Message: "msg"}
+	if v, e := fmt.Sprint(r.Error), fmt.Sprint(expected); v != e {
+		t.Errorf("Body did not match\n%s\n%s", v, e)
+	}
+}
+
+func TestUnparsableStatusCode(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	var handled error
+	ctxerr.AddHandleHook(func(err error) { handled = err })
+
+	ctx := ctxerr.SetField(context.Background(), ctxerr.FieldKeyStatusCode, "teapot")
+	sc, _ := ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code"), false, false)
+	if sc != http.StatusInternalServerError {
+		t.Error("status code should be the default", sc)
+	}
+	if v := ctxerr.AllFields(handled)["status code"]; v != "teapot" {
+		t.Errorf("the raw status code should be logged %#v", v)
+	}
+}
+
+func TestWriteResponseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "none",
+			err:      ctxerr.New(context.Background(), "code"),
+			expected: "",
+		},
+		{
+			name:     "rounds up to seconds",
+			err:      ctxerr.New(ctxerr.SetRetryAfter(context.Background(), 1500*time.Millisecond), "code"),
+			expected: "2",
+		},
+		{
+			name:     "wrapped",
+			err:      ctxerr.Wrap(context.Background(), ctxerr.New(ctxerr.SetRetryAfter(context.Background(), time.Minute), "code"), "wrap"),
+			expected: "60",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ctxerrhttp.WriteResponse(rec, tt.err, false, false)
+			if v := rec.Header().Get("Retry-After"); v != tt.expected {
+				t.Error("Retry-After did not match", v, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHiddenFields(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "foo", "bar")
+	err := ctxerr.NewHTTP(ctx, "code", "action", 400, "msg")
+
+	tests := []struct {
+		name           string
+		opts           ctxerrhttp.ResponseOptions
+		expectedFields []string
+	}{
+		{
+			name:           "default",
+			opts:           ctxerrhttp.ResponseOptions{ShowFields: true},
+			expectedFields: []string{"foo"},
+		},
+		{
+			name:           "custom",
+			opts:           ctxerrhttp.ResponseOptions{ShowFields: true, HiddenFields: []string{"foo"}},
+			expectedFields: []string{ctxerr.FieldKeyLocation},
+		},
+		{
+			name:           "none hidden",
+			opts:           ctxerrhttp.ResponseOptions{ShowFields: true, HiddenFields: []string{}},
+			expectedFields: []string{ctxerr.FieldKeyLocation, "foo"},
+		},
+		{
+			name:           "hide fields",
+			opts:           ctxerrhttp.ResponseOptions{},
+			expectedFields: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, r := ctxerrhttp.StatusCodeAndResponseWithOptions(err, tt.opts)
+			if sc != 400 || r.Error.Code != "code" || r.Error.Action != "action" {
+				t.Error("code, action, or status did not match", sc, r.Error.Code, r.Error.Action)
+			}
+
+			keys := []string{}
+			for k := range r.Error.Fields {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if v, e := fmt.Sprint(keys), fmt.Sprint(tt.expectedFields); v != e && !(len(keys) == 0 && tt.expectedFields == nil) {
+				t.Error("Fields did not match", v, e)
+			}
+		})
+	}
+
+	caused := ctxerr.WrapCause(context.Background(), err, errors.New("why"), "wrap")
+	_, r := ctxerrhttp.StatusCodeAndResponseWithOptions(caused, ctxerrhttp.ResponseOptions{ShowFields: true})
+	if _, ok := r.Error.Fields[ctxerr.FieldKeyCause]; ok {
+		t.Error("the cause should be hidden by default", r.Error.Fields)
+	}
+}
+
+func TestDefaultStatusCode(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		err      error
+		opts     ctxerrhttp.ResponseOptions
+		expected int
+	}{
+		{
+			name:     "explicit status",
+			err:      ctxerr.NewHTTP(ctx, "code", "", 404, "msg"),
+			opts:     ctxerrhttp.ResponseOptions{DefaultStatusCode: 400},
+			expected: 404,
+		},
+		{
+			name:     "custom default",
+			err:      ctxerr.New(ctx, "code", "msg"),
+			opts:     ctxerrhttp.ResponseOptions{DefaultStatusCode: 400},
+			expected: 400,
+		},
+		{
+			name:     "library default",
+			err:      ctxerr.New(ctx, "code", "msg"),
+			expected: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sc, _ := ctxerrhttp.StatusCodeAndResponseWithOptions(tt.err, tt.opts); sc != tt.expected {
+				t.Error("status code did not match", sc, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStructuredAction(t *testing.T) {
+	type action struct {
+		Key    string         `json:"key"`
+		Params map[string]any `json:"params"`
+	}
+
+	ctx := ctxerr.SetAction(context.Background(), "fix the zip")
+	_, r := ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code", "msg"), false, false)
+	if r.Error.Action != "fix the zip" || r.Error.ActionData != nil {
+		t.Error("string action did not match", r.Error.Action, r.Error.ActionData)
+	}
+
+	a := action{Key: "zip.invalid", Params: map[string]any{"length": 5}}
+	ctx = ctxerr.SetActionValue(context.Background(), a)
+	_, r = ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code", "msg"), false, false)
+	if r.Error.Action != "" || !reflect.DeepEqual(r.Error.ActionData, a) {
+		t.Error("struct action did not match", r.Error.Action, r.Error.ActionData)
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := `{"error":{"code":"code","actionData":{"key":"zip.invalid","params":{"length":5}}}}`; string(b) != e {
+		t.Error("json did not match", string(b), e)
+	}
+}
+
+func TestNonStringFields(t *testing.T) {
+	type code int
+
+	ctx := ctxerr.SetField(context.Background(), ctxerr.FieldKeyCode, code(42))
+	ctx = ctxerr.SetField(ctx, ctxerrhttp.FieldKeyTraceID, 123)
+	ctx = ctxerr.SetActionValue(ctx, 7)
+	err := ctxerr.New(ctx, "", "msg")
+
+	_, r := ctxerrhttp.StatusCodeAndResponse(err, false, false)
+	if r.Error.Code != "42" {
+		t.Error("code did not match", r.Error.Code)
+	}
+	if r.Error.TraceID != "123" {
+		t.Error("trace ID did not match", r.Error.TraceID)
+	}
+	if r.Error.ActionData != 7 {
+		t.Error("action did not match", r.Error.ActionData)
+	}
+}
+
+func TestCategoryStatusMap(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		err      error
+		opts     ctxerrhttp.ResponseOptions
+		expected int
+	}{
+		{
+			name:     "typed category",
+			err:      ctxerr.New(ctxerr.SetCategory(ctx, ctxerr.CategoryNotFound), "code"),
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "string category",
+			err:      ctxerr.New(ctxerr.SetCategory(ctx, "conflict"), "code"),
+			expected: http.StatusConflict,
+		},
+		{
+			name:     "explicit status wins",
+			err:      ctxerr.NewHTTP(ctxerr.SetCategory(ctx, ctxerr.CategoryNotFound), "code", "", http.StatusGone),
+			expected: http.StatusGone,
+		},
+		{
+			name:     "unmapped category",
+			err:      ctxerr.New(ctxerr.SetCategory(ctx, "unmapped"), "code"),
+			opts:     ctxerrhttp.ResponseOptions{DefaultStatusCode: http.StatusBadRequest},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "uncomparable category",
+			err:      ctxerr.New(ctxerr.SetCategory(ctx, []string{"not_found"}), "code"),
+			expected: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sc, _ := ctxerrhttp.StatusCodeAndResponseWithOptions(tt.err, tt.opts); sc != tt.expected {
+				t.Error("status code did not match", sc, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEnsureTraceID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	ctx, id := ctxerrhttp.EnsureTraceID(context.Background())
+	if !uuid.MatchString(id) {
+		t.Error("generated ID should be a UUID", id)
+	}
+	if v := ctxerr.Fields(ctx)[ctxerrhttp.FieldKeyTraceID]; v != id {
+		t.Error("ID should be on the context", v)
+	}
+	if _, again := ctxerrhttp.EnsureTraceID(ctx); again != id {
+		t.Error("existing ID should be kept", again, id)
+	}
+
+	_, r := ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code"), false, false)
+	if r.Error.TraceID != id {
+		t.Error("ID should be in the response", r.Error.TraceID, id)
+	}
+
+	errID, err := ctxerrhttp.EnsureErrorTraceID(ctx, ctxerr.New(context.Background(), "code"))
+	if errID != id {
+		t.Error("ID should come from the context", errID, id)
+	}
+	if v := ctxerr.AllFields(err)[ctxerrhttp.FieldKeyTraceID]; v != id {
+		t.Error("ID should be on the error", v)
+	}
+
+	errID, err = ctxerrhttp.EnsureErrorTraceID(context.Background(), errors.New("err"))
+	if !uuid.MatchString(errID) {
+		t.Error("ID should be generated", errID)
+	}
+	if _, r := ctxerrhttp.StatusCodeAndResponse(err, false, false); r.Error.TraceID != errID {
+		t.Error("generated ID should be in the response", r.Error.TraceID, errID)
+	}
+
+	defer func(f func(context.Context) string) { ctxerrhttp.TraceID = f }(ctxerrhttp.TraceID)
+	ctxerrhttp.TraceID = func(ctx context.Context) string {
+		id, _ := ctx.Value(testTraceKey{}).(string)
+		return id
+	}
+	traced := context.WithValue(context.Background(), testTraceKey{}, "span")
+	if errID, _ := ctxerrhttp.EnsureErrorTraceID(traced, errors.New("err")); errID != "span" {
+		t.Error("ID should come from TraceID of the context", errID)
+	}
+
+	shared := ctxerr.New(context.Background(), "shared")
+	first, _ := ctxerrhttp.EnsureErrorTraceID(context.Background(), shared)
+	second, _ := ctxerrhttp.EnsureErrorTraceID(context.Background(), shared)
+	if first == second || ctxerr.HasField(shared, ctxerrhttp.FieldKeyTraceID) {
+		t.Error("the error passed in should not be changed", first, second)
+	}
+}
+
+type testTraceKey struct{}
+
+func TestWriteResponseTraceID(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+
+	var handled error
+	ctxerr.AddHandleHook(func(err error) { handled = err })
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "ctxerr", err: ctxerr.New(context.Background(), "code")},
+		{name: "go error", err: errors.New("err")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ctxerrhttp.WriteResponse(rec, tt.err, false, false)
+
+			var r ctxerrhttp.ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&r); err != nil {
+				t.Fatal("could not decode body", err)
+			}
+			if r.Error.TraceID == "" {
+				t.Fatal("response should have a trace ID")
+			}
+			if v := ctxerr.AllFields(handled)[ctxerrhttp.FieldKeyTraceID]; v != r.Error.TraceID {
+				t.Error("logged trace ID should match the response", v, r.Error.TraceID)
+			}
+		})
+	}
+}
+
+func TestFieldKeyMap(t *testing.T) {
+	in := ctxerr.NewInstance()
+	in.SetFieldKeyPrefix("err.")
+	in.FieldKeyMap[ctxerrhttp.FieldKeyTraceID] = "err.trace"
+
+	ctx := in.SetAction(context.Background(), "action")
+	ctx = in.SetHTTPStatusCode(ctx, http.StatusConflict)
+	ctx = in.SetField(ctx, "err.trace", "traceID")
+	ctx = in.SetField(ctx, "foo", "bar")
+	err := in.New(ctx, "code", "msg")
+
+	sc, r := ctxerrhttp.StatusCodeAndResponseWithOptions(err, ctxerrhttp.ResponseOptions{ShowFields: true, Instance: &in})
+	if sc != http.StatusConflict {
+		t.Error("status code did not match", sc)
+	}
+	if r.Error.Code != "code" || r.Error.Action != "action" || r.Error.TraceID != "traceID" {
+		t.Error("response did not match", r.Error)
+	}
+	if !reflect.DeepEqual(r.Error.Fields, map[string]any{"foo": "bar"}) {
+		t.Error("fields did not match", r.Error.Fields)
+	}
+
+	for _, err := range []error{
+		in.NewHTTP(context.Background(), "code", "action", http.StatusNotFound, "msg"),
+		in.NewHTTPStatus(in.SetAction(context.Background(), "action"), http.StatusNotFound),
+	} {
+		sc, r := ctxerrhttp.StatusCodeAndResponseWithOptions(err, ctxerrhttp.ResponseOptions{Instance: &in})
+		if sc != http.StatusNotFound || r.Error.Action != "action" {
+			t.Error("status code and action should use the instance keys", sc, r.Error)
+		}
+	}
+}
+
+func TestPublicMessage(t *testing.T) {
+	ctx := context.Background()
+	err := ctxerr.New(ctxerr.SetPublicMessage(ctx, "inner public"), "code", "secret details")
+	err = ctxerr.Wrap(ctxerr.SetPublicMessage(ctx, "Something went wrong"), err, "", "internal wrap")
+
+	for _, showMessage := range []bool{true, false} {
+		_, r := ctxerrhttp.StatusCodeAndResponse(err, showMessage, true)
+		if r.Error.Message != "Something went wrong" {
+			t.Error("Message should be the outermost public message", r.Error.Message)
+		}
+		if _, ok := r.Error.Fields[ctxerr.FieldKeyPublicMessage]; ok {
+			t.Error("public message should not be in fields")
+		}
+	}
+
+	if msg := err.Error(); msg != "internal wrap : secret details" {
+		t.Error("internal message did not match", msg)
+	}
+	if v := ctxerr.AllFields(err)[ctxerr.FieldKeyPublicMessage]; v != "inner public" {
+		t.Error("public message should still be in fields for logs", v)
+	}
+
+	_, r := ctxerrhttp.StatusCodeAndResponse(ctxerr.New(ctx, "code", "msg"), false, false)
+	if r.Error.Message != "" {
+		t.Error("message should be hidden without a public message", r.Error.Message)
+	}
+}
+
+func TestFromResponse(t *testing.T) {
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	ctx = ctxerr.SetField(ctx, ctxerrhttp.FieldKeyTraceID, "trace")
+	validation := errors.Join(
+		ctxerr.New(ctxerr.SetField(context.Background(), ctxerrhttp.FieldKeyValidationField, "zip"), "ZIP", "zip is not 5 digits"),
+		ctxerr.New(ctxerr.SetField(context.Background(), ctxerrhttp.FieldKeyValidationField, "name"), "NAME", "name is required"),
+	)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "fields", err: ctxerr.NewHTTP(ctx, "code", "action", http.StatusConflict, "msg")},
+		{name: "structured action", err: ctxerr.New(ctxerr.SetActionValue(ctx, map[string]any{"key": "retry"}), "code", "msg")},
+		{name: "validation", err: ctxerr.WrapHTTP(ctx, validation, "code", "", http.StatusBadRequest, "invalid")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode, expected := ctxerrhttp.StatusCodeAndResponse(tt.err, true, true)
+			body, err := json.Marshal(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fromErr, err := ctxerrhttp.FromResponse(context.Background(), statusCode, body)
+			if err != nil {
+				t.Fatal("could not parse the response", err)
+			}
+			sc, actual := ctxerrhttp.StatusCodeAndResponse(fromErr, true, true)
+			if sc != statusCode {
+				t.Error("status code did not match", sc, statusCode)
+			}
+			// The message of the downstream error includes the messages of the validation errors
+			if !strings.HasPrefix(actual.Error.Message, expected.Error.Message) {
+				t.Error("message did not match", actual.Error.Message, expected.Error.Message)
+			}
+			actual.Error.Message = expected.Error.Message
+			if a, e := fmt.Sprint(actual), fmt.Sprint(expected); a != e {
+				t.Errorf("response did not match\n%s\n%s", a, e)
+			}
+		})
+	}
+
+	if _, err := ctxerrhttp.FromResponse(context.Background(), http.StatusBadGateway, []byte("<html>")); err == nil {
+		t.Error("a body that is not JSON should return an error")
+	}
+
+	err, _ := ctxerrhttp.FromResponse(context.Background(), http.StatusBadGateway, []byte(`{"error":{"code":"code"}}`))
+	if err.Error() != http.StatusText(http.StatusBadGateway) {
+		t.Error("an empty message should use the status text", err)
+	}
+}
+
+func TestResponseBuilder(t *testing.T) {
+	docs := map[string]string{"NOT_FOUND": "https://example.com/docs/not-found"}
+	ctx := ctxerr.SetField(context.Background(), "a", "a")
+	err := ctxerr.New(ctxerr.SetCategory(ctx, ctxerr.CategoryNotFound), "NOT_FOUND", "msg")
+
+	rb := ctxerrhttp.NewResponseBuilder().
+		WithMessage(true).
+		WithFields(true).
+		WithStatusDefault(http.StatusBadRequest).
+		WithExtra(map[string]any{"requestID": "req"}).
+		WithDetailsFunc(func(err error, d *ctxerrhttp.Details) {
+			if url, ok := docs[d.Code]; ok {
+				if d.Extra == nil {
+					d.Extra = map[string]any{}
+				}
+				d.Extra["docs_url"] = url
+			}
+		})
+
+	statusCode, r := rb.Build(err)
+	if statusCode != http.StatusNotFound {
+		t.Error("status code did not match", statusCode)
+	}
+	if r.Error.Code != "NOT_FOUND" || r.Error.Message != "msg" || r.Error.Fields["a"] != "a" {
+		t.Error("standard details did not match", r.Error)
+	}
+	expectedExtra := map[string]any{"requestID": "req", "docs_url": "https://example.com/docs/not-found"}
+	if !reflect.DeepEqual(r.Error.Extra, expectedExtra) {
+		t.Error("extra did not match", r.Error.Extra, expectedExtra)
+	}
+
+	b, _ := json.Marshal(r)
+	if !strings.Contains(string(b), `"extra":{"docs_url":"https://example.com/docs/not-found","requestID":"req"}`) {
+		t.Error("extra should be in the JSON", string(b))
+	}
+
+	statusCode, r = rb.Build(errors.New("plain"))
+	if statusCode != http.StatusBadRequest {
+		t.Error("default status code did not match", statusCode)
+	}
+	if !reflect.DeepEqual(r.Error.Extra, map[string]any{"requestID": "req"}) {
+		t.Error("extra should not include the docs URL for other codes", r.Error.Extra)
+	}
+
+	sc, expected := ctxerrhttp.StatusCodeAndResponse(err, false, false)
+	if sc2, actual := ctxerrhttp.NewResponseBuilder().Build(err); sc2 != sc || !reflect.DeepEqual(actual, expected) {
+		t.Error("an empty builder should match StatusCodeAndResponse", actual, expected)
+	}
+}
+
+func TestRegisterCodeDefaults(t *testing.T) {
+	saved := ctxerr.Global()
+	defer ctxerr.SetGlobal(saved)
+	ctxerr.RegisterCodeDefaults("NOT_FOUND", "check the id", http.StatusNotFound)
+
+	tests := []struct {
+		name               string
+		err                error
+		expectedStatusCode int
+		expectedAction     string
+	}{
+		{
+			name:               "defaults",
+			err:                ctxerr.New(context.Background(), "NOT_FOUND", "msg"),
+			expectedStatusCode: http.StatusNotFound,
+			expectedAction:     "check the id",
+		},
+		{
+			name:               "wrapped",
+			err:                ctxerr.Wrap(context.Background(), errors.New("plain"), "NOT_FOUND"),
+			expectedStatusCode: http.StatusNotFound,
+			expectedAction:     "check the id",
+		},
+		{
+			name:               "explicit wins",
+			err:                ctxerr.NewHTTP(context.Background(), "NOT_FOUND", "try again", http.StatusGone, "msg"),
+			expectedStatusCode: http.StatusGone,
+			expectedAction:     "try again",
+		},
+		{
+			name:               "other code",
+			err:                ctxerr.New(context.Background(), "OTHER", "msg"),
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statusCode, r := ctxerrhttp.StatusCodeAndResponse(tt.err, false, false)
+			if statusCode != tt.expectedStatusCode {
+				t.Error("status code did not match", statusCode, tt.expectedStatusCode)
+			}
+			if r.Error.Action != tt.expectedAction {
+				t.Error("action did not match", r.Error.Action, tt.expectedAction)
+			}
+		})
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	defer func(key string) { ctxerrhttp.MessageKey = key }(ctxerrhttp.MessageKey)
+
+	details := ctxerrhttp.Details{Code: "code", Message: "msg", Fields: map[string]any{"a": "a"}}
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{name: "default", key: ctxerrhttp.MessageKey, expected: `{"code":"code","messsage":"msg","fields":{"a":"a"}}`},
+		{name: "correct spelling", key: "message", expected: `{"code":"code","fields":{"a":"a"},"message":"msg"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctxerrhttp.MessageKey = tt.key
+			b, err := json.Marshal(ctxerrhttp.ErrorResponse{Error: details})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := `{"error":` + tt.expected + `}`; string(b) != expected {
+				t.Errorf("JSON did not match\n%s\n%s", b, expected)
+			}
+
+			var r ctxerrhttp.ErrorResponse
+			if err := json.Unmarshal(b, &r); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Error, details) {
+				t.Error("round trip did not match", r.Error, details)
+			}
+		})
+	}
+
+	ctxerrhttp.MessageKey = "messsage"
+	for _, body := range []string{`{"code":"code","message":"msg"}`, `{"code":"code","messsage":"msg"}`} {
+		var d ctxerrhttp.Details
+		if err := json.Unmarshal([]byte(body), &d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Message != "msg" {
+			t.Error("both spellings should be accepted", body, d.Message)
+		}
+	}
+}
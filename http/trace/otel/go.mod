@@ -0,0 +1,10 @@
+module github.com/mvndaai/ctxerr/http/trace/otel
+
+go 1.23
+
+require (
+	github.com/mvndaai/ctxerr v0.0.0-20261015073729-0ee29c0140ef
+	go.opentelemetry.io/otel/trace v1.28.0
+)
+
+require go.opentelemetry.io/otel v1.28.0
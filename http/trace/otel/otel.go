@@ -0,0 +1,95 @@
+/*
+Package otel sets ctxerrhttp.TraceID to use OpenTelemetry trace IDs.
+
+Importing the package replaces ctxerrhttp.TraceID.
+
+	import _ "github.com/mvndaai/ctxerr/http/trace/otel"
+
+To avoid the side effect use TraceID directly.
+
+Attributes converts the fields of an error into span attributes.
+
+	span.SetAttributes(ctxerrotel.Attributes(err)...)
+	span.RecordError(err)
+*/
+package otel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	ctxerrhttp.TraceID = TraceID
+}
+
+// TraceID gets the trace ID of the span on the context or an empty string if there isn't one
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Attributes converts all the fields of the error into attributes sorted by key
+// Strings, bools, integers, and floats keep their type, slices become string slices, and other values are stringified
+func Attributes(err error) []attribute.KeyValue {
+	f := ctxerr.AllFields(err)
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, attributeValue(k, f[k]))
+	}
+	return attrs
+}
+
+// attributeValue creates an attribute with the type of the value
+func attributeValue(k string, v any) attribute.KeyValue {
+	switch v := v.(type) {
+	case string:
+		return attribute.String(k, v)
+	case bool:
+		return attribute.Bool(k, v)
+	case int:
+		return attribute.Int(k, v)
+	case int8:
+		return attribute.Int64(k, int64(v))
+	case int16:
+		return attribute.Int64(k, int64(v))
+	case int32:
+		return attribute.Int64(k, int64(v))
+	case int64:
+		return attribute.Int64(k, v)
+	case uint8:
+		return attribute.Int64(k, int64(v))
+	case uint16:
+		return attribute.Int64(k, int64(v))
+	case uint32:
+		return attribute.Int64(k, int64(v))
+	case float32:
+		return attribute.Float64(k, float64(v))
+	case float64:
+		return attribute.Float64(k, v)
+	case []string:
+		return attribute.StringSlice(k, v)
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			s[i] = fmt.Sprint(e)
+		}
+		return attribute.StringSlice(k, s)
+	}
+	return attribute.String(k, fmt.Sprint(v))
+}
@@ -0,0 +1,81 @@
+package otel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mvndaai/ctxerr"
+	ctxerrhttp "github.com/mvndaai/ctxerr/http"
+	ctxerrotel "github.com/mvndaai/ctxerr/http/trace/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceID(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	expected := "4bf92f3577b34da6a3ce929d0e0e4736"
+	if v := ctxerrotel.TraceID(ctx); v != expected {
+		t.Error("trace ID did not match", v, expected)
+	}
+	if v := ctxerrotel.TraceID(context.Background()); v != "" {
+		t.Error("expected no trace ID", v)
+	}
+
+	err := ctxerr.New(ctx, "code", "msg")
+	if _, r := ctxerrhttp.StatusCodeAndResponse(err, false, false); r.Error.TraceID != expected {
+		t.Error("response trace ID did not match", r.Error.TraceID, expected)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	ctx := ctxerr.SetFields(context.Background(), map[string]any{
+		"string":   "s",
+		"bool":     true,
+		"int":      1,
+		"int32":    int32(2),
+		"int64":    int64(3),
+		"uint8":    uint8(4),
+		"float":    1.5,
+		"strings":  []string{"a", "b"},
+		"duration": 1500 * time.Millisecond,
+		"struct":   struct{ A int }{A: 1},
+	})
+	err := ctxerr.Wrap(context.Background(), ctxerr.New(ctx, "code"), "")
+
+	expected := map[attribute.Key]attribute.Value{
+		"string":                attribute.StringValue("s"),
+		"bool":                  attribute.BoolValue(true),
+		"int":                   attribute.IntValue(1),
+		"int32":                 attribute.Int64Value(2),
+		"int64":                 attribute.Int64Value(3),
+		"uint8":                 attribute.Int64Value(4),
+		"float":                 attribute.Float64Value(1.5),
+		"strings":               attribute.StringSliceValue([]string{"a", "b"}),
+		"duration":              attribute.StringValue("1.5s"),
+		"struct":                attribute.StringValue("{1}"),
+		ctxerr.FieldKeyCode:     attribute.StringValue("code"),
+		ctxerr.FieldKeyLocation: attribute.StringSliceValue([]string{"otel_test.TestAttributes", "otel_test.TestAttributes"}),
+	}
+
+	attrs := ctxerrotel.Attributes(err)
+	if len(attrs) != len(expected) {
+		t.Error("attribute count did not match", len(attrs), len(expected))
+	}
+	for i, a := range attrs {
+		if i > 0 && attrs[i-1].Key >= a.Key {
+			t.Error("attributes should be sorted by key", attrs[i-1].Key, a.Key)
+		}
+		if e, ok := expected[a.Key]; !ok || e != a.Value {
+			t.Error("attribute did not match", a.Key, a.Value.Emit(), e.Emit())
+		}
+	}
+
+	if attrs := ctxerrotel.Attributes(nil); len(attrs) != 0 {
+		t.Error("nil should have no attributes", attrs)
+	}
+}
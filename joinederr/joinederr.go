@@ -1,5 +1,7 @@
 package joinederr
 
+import "iter"
+
 type ErrorIterator interface {
 	Next() error
 	HasNext() bool
@@ -52,3 +54,69 @@ func (bfu *depthFirstUnwrapper) HasNext() bool {
 func NewDepthFirstIterator(err error) ErrorIterator {
 	return &depthFirstUnwrapper{next: err}
 }
+
+// Walk calls fn for each error in depth first order until fn returns false
+func Walk(err error, fn func(err error) bool) {
+	iter := NewDepthFirstIterator(err)
+	for iter.HasNext() {
+		if !fn(iter.Next()) {
+			return
+		}
+	}
+}
+
+// All is a depth first sequence of every error in the tree to use with range
+//
+//	for e := range joinederr.All(err) {
+func All(err error) iter.Seq[error] {
+	return func(yield func(error) bool) {
+		Walk(err, yield)
+	}
+}
+
+type breadthFirstUnwrapper struct {
+	queue []error
+}
+
+func (bfu *breadthFirstUnwrapper) Next() error {
+	if len(bfu.queue) == 0 {
+		return nil
+	}
+
+	// Set return value
+	r := bfu.queue[0]
+
+	// Queue the children to be visited after all the siblings
+	bfu.queue = bfu.queue[1:]
+	if x, ok := r.(interface{ Unwrap() error }); ok {
+		bfu.queue = append(bfu.queue, splitJoined(x.Unwrap())...)
+	}
+
+	// Return
+	return r
+}
+
+func (bfu *breadthFirstUnwrapper) HasNext() bool {
+	return len(bfu.queue) > 0
+}
+
+// NewBreadthFirstIterator visits every error at one depth before descending
+func NewBreadthFirstIterator(err error) ErrorIterator {
+	return &breadthFirstUnwrapper{queue: splitJoined(err)}
+}
+
+// splitJoined replaces joined errors with their non-nil branches
+func splitJoined(err error) []error {
+	if err == nil {
+		return nil
+	}
+	x, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	errs := []error{}
+	for _, e := range x.Unwrap() {
+		errs = append(errs, splitJoined(e)...)
+	}
+	return errs
+}
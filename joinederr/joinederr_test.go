@@ -9,7 +9,7 @@ import (
 	"github.com/mvndaai/ctxerr/joinederr"
 )
 
-func TestBreadthFirst(t *testing.T) {
+func testTree() error {
 	/*
 				a
 			   / \
@@ -29,7 +29,11 @@ func TestBreadthFirst(t *testing.T) {
 	c := fmt.Errorf("c\n%w", d)
 	e := errors.New("e")
 	b := fmt.Errorf("b\n%w", errors.Join(c, e))
-	a := fmt.Errorf("a\n%w", errors.Join(b, f))
+	return fmt.Errorf("a\n%w", errors.Join(b, f))
+}
+
+func TestDepthFirst(t *testing.T) {
+	a := testTree()
 
 	msgs := []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}
 	actualMsg := a.Error()
@@ -69,3 +73,76 @@ func TestBreadthFirst(t *testing.T) {
 		t.Error("this there should be nothing left")
 	}
 }
+
+func TestBreadthFirst(t *testing.T) {
+	expected := []string{"a", "b", "f", "c", "e", "g", "d", "h", "i"}
+
+	actual := []string{}
+	iter := joinederr.NewBreadthFirstIterator(testTree())
+	for iter.HasNext() {
+		actual = append(actual, strings.Split(iter.Next().Error(), "\n")[0])
+	}
+
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("order did not match\n%v\n%v", actual, expected)
+	}
+
+	if iter.Next() != nil {
+		t.Error("this there should be nothing left")
+	}
+
+	if joinederr.NewBreadthFirstIterator(nil).HasNext() {
+		t.Error("nil should have nothing to iterate")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	visited := []string{}
+	joinederr.Walk(testTree(), func(err error) bool {
+		msg := strings.Split(err.Error(), "\n")[0]
+		visited = append(visited, msg)
+		return msg != "e"
+	})
+
+	expected := []string{"a", "b", "c", "d", "e"}
+	if strings.Join(visited, ",") != strings.Join(expected, ",") {
+		t.Errorf("visited did not match\n%v\n%v", visited, expected)
+	}
+
+	joinederr.Walk(nil, func(err error) bool {
+		t.Error("nil should not be walked")
+		return true
+	})
+}
+
+func TestAll(t *testing.T) {
+	expected := []string{}
+	iter := joinederr.NewDepthFirstIterator(testTree())
+	for iter.HasNext() {
+		expected = append(expected, iter.Next().Error())
+	}
+
+	actual := []string{}
+	for err := range joinederr.All(testTree()) {
+		actual = append(actual, err.Error())
+	}
+	if strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("order did not match\n%v\n%v", actual, expected)
+	}
+
+	visited := []string{}
+	for err := range joinederr.All(testTree()) {
+		msg := strings.Split(err.Error(), "\n")[0]
+		visited = append(visited, msg)
+		if msg == "c" {
+			break
+		}
+	}
+	if strings.Join(visited, ",") != "a,b,c" {
+		t.Error("break should stop iterating", visited)
+	}
+
+	for range joinederr.All(nil) {
+		t.Error("nil should have nothing to iterate")
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Package metrics has handle hooks for creating metrics on errors.
+
+	ctxerr.AddHandleHook(metrics.PrometheusHandleHook(prometheus.DefaultRegisterer))
+	ctxerr.AddHandleHook(ctxerr.DefaultLogHook)
+*/
+package metrics
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mvndaai/ctxerr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UnknownLabel is the label value used when an error does not have the field
+const UnknownLabel = "unknown"
+
+// DefaultLabelKeys are the field keys used as labels when none are passed to PrometheusHandleHook
+var DefaultLabelKeys = []string{ctxerr.FieldKeyCode, ctxerr.FieldKeyCategory}
+
+// PrometheusHandleHook creates a hook that increments the counter ctxerr_errors_total for each handled error
+// The counter is labeled by the values of the field keys, which must be valid Prometheus label names
+func PrometheusHandleHook(reg prometheus.Registerer, labelKeys ...string) func(error) {
+	if len(labelKeys) == 0 {
+		labelKeys = DefaultLabelKeys
+	}
+
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ctxerr_errors_total",
+		Help: "Number of errors handled by ctxerr",
+	}, labelKeys)
+	if err := reg.Register(counter); err != nil {
+		are := prometheus.AlreadyRegisteredError{}
+		if !errors.As(err, &are) {
+			panic(err)
+		}
+		counter = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+
+	return func(err error) {
+		if err == nil {
+			return
+		}
+
+		fields := ctxerr.AllFields(err)
+		labels := make([]string, len(labelKeys))
+		for i, k := range labelKeys {
+			labels[i] = UnknownLabel
+			if v, ok := fields[k]; ok && v != nil && fmt.Sprint(v) != "" {
+				labels[i] = fmt.Sprint(v)
+			}
+		}
+		counter.WithLabelValues(labels...).Inc()
+	}
+}
@@ -0,0 +1,81 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mvndaai/ctxerr"
+	"github.com/mvndaai/ctxerr/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusHandleHook(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hook := metrics.PrometheusHandleHook(reg)
+
+	ctx := ctxerr.SetCategory(context.Background(), "category")
+	hook(ctxerr.New(ctx, "code", "msg"))
+	hook(ctxerr.New(ctx, "code", "msg"))
+	hook(ctxerr.New(context.Background(), "", "msg"))
+	hook(errors.New("plain"))
+	hook(nil)
+
+	// Registering again reuses the counter
+	again := metrics.PrometheusHandleHook(reg)
+	again(ctxerr.New(ctx, "code", "msg"))
+
+	counter, err := reg.Gather()
+	if err != nil {
+		t.Fatal("could not gather", err)
+	}
+	if len(counter) != 1 || counter[0].GetName() != "ctxerr_errors_total" {
+		t.Fatal("expected only the errors counter", counter)
+	}
+
+	tests := []struct {
+		code     string
+		category string
+		expected float64
+	}{
+		{code: "code", category: "category", expected: 3},
+		{code: metrics.UnknownLabel, category: metrics.UnknownLabel, expected: 2},
+	}
+	vec := counter[0].GetMetric()
+	if len(vec) != len(tests) {
+		t.Error("label combinations did not match", len(vec))
+	}
+	for _, tt := range tests {
+		for _, m := range vec {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels[ctxerr.FieldKeyCode] == tt.code && labels[ctxerr.FieldKeyCategory] == tt.category {
+				if v := m.GetCounter().GetValue(); v != tt.expected {
+					t.Error("count did not match", tt.code, v, tt.expected)
+				}
+			}
+		}
+	}
+}
+
+func TestPrometheusHandleHookLabelKeys(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hook := metrics.PrometheusHandleHook(reg, "tenant")
+
+	hook(ctxerr.New(ctxerr.SetField(context.Background(), "tenant", "acme"), "code", "msg"))
+	hook(ctxerr.New(context.Background(), "code", "msg"))
+
+	expected := `
+# HELP ctxerr_errors_total Number of errors handled by ctxerr
+# TYPE ctxerr_errors_total counter
+ctxerr_errors_total{tenant="acme"} 1
+ctxerr_errors_total{tenant="unknown"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "ctxerr_errors_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -8,7 +8,7 @@ function cmd_on_packages() {
 	echo "$1 packages..."
 	for package in "${packages[@]}"; do
 		pushd $SCRIPT_DIR/$package &> /dev/null
-		echo -e "\n${1}: $(go list -m)"
+		echo -e "\n${1}: $(GOWORK=off go list -m)"
 		for cmd in "${@:2}"; do
 			($cmd)
 		done